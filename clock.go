@@ -0,0 +1,113 @@
+package threads
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time used by all the helpers of this
+// library, the real clock is used unless a different one is
+// injected on the context using ContextWithClock.
+//
+// It is mostly useful for tests, see the threadstest package
+// for a fake implementation.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	Sleep(d time.Duration)
+
+	// WithTimeout and WithDeadline work like the functions of the context
+	// package, but the deadline is measured using the clock:
+	WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc)
+	WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc)
+}
+
+// Timer is the equivalent of a *time.Timer for a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the equivalent of a *time.Ticker for a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+type ctxClockKey struct{}
+
+// ContextWithClock returns a context that makes all
+// the helpers of this library use the given clock.
+func ContextWithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, ctxClockKey{}, clock)
+}
+
+// ClockFromContext returns the clock set with ContextWithClock,
+// or the real clock if none was set.
+func ClockFromContext(ctx context.Context) Clock {
+	clock, _ := ctx.Value(ctxClockKey{}).(Clock)
+	if clock != nil {
+		return clock
+	}
+	return RealClock()
+}
+
+// RealClock returns a Clock that uses the functions of the time package.
+func RealClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, d)
+}
+
+func (realClock) WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(parent, deadline)
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
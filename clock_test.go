@@ -0,0 +1,173 @@
+package threads
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+// stubClock uses the real clock, except for the methods overridden
+// by the test, so it can check which of them each helper uses.
+type stubClock struct {
+	Clock
+
+	mux         sync.Mutex
+	now         func() time.Time
+	withTimeout func(parent context.Context, d time.Duration) (context.Context, context.CancelFunc)
+}
+
+func (c *stubClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.now != nil {
+		return c.now()
+	}
+	return c.Clock.Now()
+}
+
+func (c *stubClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *stubClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if c.withTimeout != nil {
+		return c.withTimeout(parent, d)
+	}
+	return c.Clock.WithTimeout(parent, d)
+}
+
+func TestClock(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should use the real clock by default", func(t *testing.T) {
+		clock := ClockFromContext(ctx)
+		tt.AssertApproxTime(t, time.Second, clock.Now(), time.Now(), "unexpected time: %v", clock.Now())
+
+		timer := clock.NewTimer(time.Millisecond)
+		<-timer.C()
+		tt.AssertEqual(t, timer.Stop(), false)
+
+		ticker := clock.NewTicker(time.Millisecond)
+		<-ticker.C()
+		<-ticker.C()
+		ticker.Stop()
+	})
+
+	t.Run("should use the clock from the context", func(t *testing.T) {
+		clock := &stubClock{Clock: RealClock()}
+		ctx := ContextWithClock(ctx, clock)
+		tt.AssertEqual(t, ClockFromContext(ctx), Clock(clock))
+	})
+
+	t.Run("should use the clock for the restart intensity window", func(t *testing.T) {
+		// Each restart happens an hour after the previous one:
+		now := tt.ParseTime(t, "2024-01-01T00:00:00Z")
+		ctx := ContextWithClock(ctx, &stubClock{
+			Clock: RealClock(),
+			now: func() time.Time {
+				now = now.Add(time.Hour)
+				return now
+			},
+		})
+
+		var numCalls int
+		g := NewGroup(ctx, WithRestartIntensity(1, time.Minute))
+		g.Go(func(ctx context.Context) error {
+			numCalls++
+			if numCalls < 5 {
+				return ErrRestartGroup
+			}
+			return nil
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 5)
+	})
+
+	t.Run("should use the clock for Group.WaitTimeout", func(t *testing.T) {
+		var timeouts []time.Duration
+		ctx := ContextWithClock(ctx, &stubClock{
+			Clock: RealClock(),
+			withTimeout: func(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+				timeouts = append(timeouts, d)
+
+				// Expires right away:
+				return context.WithTimeout(parent, 0)
+			},
+		})
+
+		releaseCh := make(chan struct{})
+		defer close(releaseCh)
+
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-releaseCh
+			return nil
+		})
+
+		err := g.WaitTimeout(time.Hour)
+		tt.AssertEqual(t, err, context.DeadlineExceeded)
+		tt.AssertEqual(t, timeouts, []time.Duration{time.Hour})
+	})
+
+	t.Run("should use the clock on the PeriodicWorker iterations", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		fakeNow := tt.ParseTime(t, "2024-01-01T00:00:00Z")
+		var timeouts []time.Duration
+		ctx = ContextWithClock(ctx, &stubClock{
+			Clock: RealClock(),
+			now: func() time.Time {
+				return fakeNow
+			},
+			withTimeout: func(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+				timeouts = append(timeouts, d)
+				return context.WithCancel(parent)
+			},
+		})
+
+		var infos []IterationInfo
+		err := PeriodicWorker(time.Hour, func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		},
+			WithIterationTimeout(time.Minute, nil),
+			WithIterationObserver(func(info IterationInfo) {
+				infos = append(infos, info)
+			}),
+		)(ctx)
+
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertEqual(t, timeouts, []time.Duration{time.Minute})
+		tt.AssertEqual(t, len(infos), 1)
+		tt.AssertEqual(t, infos[0].Start, fakeNow)
+		tt.AssertEqual(t, infos[0].Duration, time.Duration(0))
+	})
+
+	t.Run("should keep ContextWithTimeMock working on top of the clock", func(t *testing.T) {
+		fakeNow := tt.ParseTime(t, "2024-01-01T00:00:00Z")
+		ctx := ContextWithClock(ctx, &stubClock{
+			Clock: RealClock(),
+			now: func() time.Time {
+				return fakeNow
+			},
+		})
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			triggerCh <- time.Time{}
+		}))
+
+		clock := ClockFromContext(ctx)
+		clock.Sleep(time.Hour)
+
+		tt.AssertEqual(t, timeAfterArgs, []time.Duration{time.Hour})
+		tt.AssertEqual(t, clock.Now(), fakeNow)
+	})
+}
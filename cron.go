@@ -0,0 +1,388 @@
+package threads
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron spec, see ParseCron.
+type CronSchedule struct {
+	spec string
+	loc  *time.Location
+
+	seconds, minutes, hours, daysOfMonth, months, daysOfWeek uint64
+
+	// Set when the field starts with * or ?, when only one of the
+	// days fields is restricted the other one is ignored:
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSeconds     = cronField{name: "second", min: 0, max: 59}
+	cronMinutes     = cronField{name: "minute", min: 0, max: 59}
+	cronHours       = cronField{name: "hour", min: 0, max: 23}
+	cronDaysOfMonth = cronField{name: "day of month", min: 1, max: 31}
+	cronMonths      = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is also accepted as Sunday:
+	cronDaysOfWeek = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a standard cron spec with 5 fields:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Or with 6 fields, where the first one is the second. Each field
+// accepts *, ?, lists, ranges and steps, e.g. "*/15", "1-5" or "1,3",
+// months and days of week also accept names, e.g. "JAN" or "MON".
+//
+// The descriptors @yearly, @annually, @monthly, @weekly, @daily,
+// @midnight and @hourly are also accepted.
+//
+// The schedule uses the local time zone unless the spec
+// starts with "CRON_TZ=<zone>" or "TZ=<zone>", e.g.:
+//
+//	CRON_TZ=America/New_York 0 2 * * *
+func ParseCron(spec string) (*CronSchedule, error) {
+	s := &CronSchedule{
+		spec: spec,
+		loc:  time.Local,
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		zone := fields[0][strings.Index(fields[0], "=")+1:]
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+		}
+		s.loc = loc
+		fields = fields[1:]
+	}
+
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "@") {
+		expanded, ok := cronDescriptors[strings.ToLower(fields[0])]
+		if !ok {
+			return nil, fmt.Errorf("invalid cron spec %q: unknown descriptor %s", spec, fields[0])
+		}
+		fields = strings.Fields(expanded)
+	}
+
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron spec %q: expected 5 or 6 fields but got %d", spec, len(fields))
+	}
+
+	var err error
+	for _, f := range []struct {
+		field cronField
+		bits  *uint64
+		value string
+	}{
+		{cronSeconds, &s.seconds, fields[0]},
+		{cronMinutes, &s.minutes, fields[1]},
+		{cronHours, &s.hours, fields[2]},
+		{cronDaysOfMonth, &s.daysOfMonth, fields[3]},
+		{cronMonths, &s.months, fields[4]},
+		{cronDaysOfWeek, &s.daysOfWeek, fields[5]},
+	} {
+		*f.bits, err = f.field.parse(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+		}
+	}
+
+	// Sunday can be either 0 or 7:
+	if s.daysOfWeek&(1<<7) != 0 {
+		s.daysOfWeek |= 1
+	}
+
+	s.domStar = strings.HasPrefix(fields[3], "*") || strings.HasPrefix(fields[3], "?")
+	s.dowStar = strings.HasPrefix(fields[5], "*") || strings.HasPrefix(fields[5], "?")
+
+	return s, nil
+}
+
+// MustParseCron works like ParseCron but panics if the spec is invalid.
+func MustParseCron(spec string) *CronSchedule {
+	s, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step for %s: %q", f.name, item)
+			}
+		}
+
+		var start, end int
+		switch {
+		case rangeStr == "*" || rangeStr == "?":
+			start, end = f.min, f.max
+		case strings.Contains(rangeStr, "-"):
+			startStr, endStr, _ := strings.Cut(rangeStr, "-")
+			var err error
+			start, err = f.value(startStr)
+			if err != nil {
+				return 0, err
+			}
+			end, err = f.value(endStr)
+			if err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			start, err = f.value(rangeStr)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			if hasStep {
+				end = f.max
+			}
+		}
+
+		if start > end {
+			return 0, fmt.Errorf("invalid range for %s: %q", f.name, item)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value for %s: %q, expected a value between %d and %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// String returns the spec used for creating the schedule.
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+// Next returns the first activation of the schedule after t,
+// or the zero time if there is none in the next 5 years.
+//
+// Activations are calculated on the wall clock of the schedule's
+// time zone, so each local time runs at most once: a time repeated
+// when the clocks go back runs only on its first occurrence, and the
+// times skipped when the clocks go forward run once, at the jump.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+
+	// Activations are first found on a copy of the wall clock
+	// in UTC, which has no DST transitions, and then converted
+	// back to the time zone of the schedule:
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	for {
+		wall = s.nextWall(wall)
+		if wall.IsZero() {
+			return time.Time{}
+		}
+
+		next := wallToLocation(wall, s.loc)
+		if next.After(t) {
+			return next
+		}
+	}
+}
+
+// nextWall returns the first time after t matching all the fields,
+// t is expected to be in UTC.
+func (s *CronSchedule) nextWall(t time.Time) time.Time {
+	yearLimit := t.Year() + 5
+	t = t.Add(time.Second)
+	for {
+		if t.Year() > yearLimit {
+			return time.Time{}
+		}
+
+		y, m, d := t.Date()
+		switch {
+		case s.months&(1<<uint(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, time.UTC)
+		case s.minutes&(1<<uint(t.Minute())) == 0:
+			t = time.Date(y, m, d, t.Hour(), t.Minute()+1, 0, 0, time.UTC)
+		case s.seconds&(1<<uint(t.Second())) == 0:
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+}
+
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	domMatch := s.daysOfMonth&(1<<uint(t.Day())) != 0
+	dowMatch := s.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	// Like in the standard cron, if both fields are
+	// restricted a day matching either of them is enough:
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// wallToLocation converts a wall clock represented in UTC to the
+// given location, picking the first occurrence if the wall clock
+// happens twice and the moment of the transition if it doesn't
+// exist due to a DST transition.
+func wallToLocation(wall time.Time, loc *time.Location) time.Time {
+	u := wall.Unix()
+
+	// Assumes there is at most one transition in a 2 day window:
+	_, offsetBefore := time.Unix(u-24*60*60, 0).In(loc).Zone()
+	_, offsetAfter := time.Unix(u+24*60*60, 0).In(loc).Zone()
+
+	before := time.Unix(u-int64(offsetBefore), 0).In(loc)
+	after := time.Unix(u-int64(offsetAfter), 0).In(loc)
+	_, beforeOffset := before.Zone()
+	_, afterOffset := after.Zone()
+	beforeValid := beforeOffset == offsetBefore
+	afterValid := afterOffset == offsetAfter
+
+	switch {
+	case beforeValid && afterValid && after.Before(before):
+		return after
+	case beforeValid:
+		return before
+	case afterValid:
+		return after
+	default:
+		// The wall clock was skipped, so the transition
+		// happened between these two instants:
+		lo, hi := after.Unix(), before.Unix()
+		for lo < hi {
+			mid := lo + (hi-lo)/2
+			if _, offset := time.Unix(mid, 0).In(loc).Zone(); offset == offsetAfter {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		return time.Unix(lo, 0).In(loc)
+	}
+}
+
+type cronSpecType interface {
+	string | *CronSchedule
+}
+
+// CronWorker creates a worker that runs doWork on each activation of a cron
+// schedule, see ParseCron for the format of the spec, until either doWork
+// returns an error or the context is cancelled.
+//
+// If doWork returns RetryWorkerIn(d) it runs again after d before resuming
+// the schedule. If the spec is invalid the worker returns the parse error.
+//
+// Activations that happen while doWork is still running are skipped.
+// The time is read from the Clock on the context,
+// so it can be mocked using the ContextWithClock function.
+func CronWorker[T cronSpecType](spec T, doWork Worker) Worker {
+	return func(ctx context.Context) error {
+		var schedule *CronSchedule
+		switch s := any(spec).(type) {
+		case string:
+			var err error
+			schedule, err = ParseCron(s)
+			if err != nil {
+				return err
+			}
+		case *CronSchedule:
+			schedule = s
+		}
+
+		// This allows us to mock the time using
+		// the ContextWithClock function:
+		clock := ClockFromContext(ctx)
+
+		now := clock.Now()
+		for {
+			next := schedule.Next(now)
+			if next.IsZero() {
+				return fmt.Errorf("cron schedule %q has no activations after %v", schedule, now)
+			}
+
+			var err error
+			for {
+				// Blocks until the next activation
+				// or until the context is cancelled:
+				select {
+				case <-ctx.Done():
+					return nil
+				case now = <-clock.After(next.Sub(now)):
+				}
+
+				// Ensures the same activation doesn't run
+				// twice if the timer fires a bit early:
+				if now.Before(next) {
+					now = next
+				}
+
+				start := clock.Now()
+				err = doWork(ctx)
+				now = now.Add(clock.Since(start))
+
+				retryErr, ok := err.(retryWorkerErr)
+				if !ok {
+					break
+				}
+				next = now.Add(retryErr.d)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
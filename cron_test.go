@@ -0,0 +1,270 @@
+package threads
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestParseCron(t *testing.T) {
+	t.Run("should reject invalid specs", func(t *testing.T) {
+		for _, test := range []struct {
+			spec          string
+			expectedError string
+		}{
+			{spec: "", expectedError: "expected 5 or 6 fields but got 0"},
+			{spec: "* * * *", expectedError: "expected 5 or 6 fields but got 4"},
+			{spec: "* * * * * * *", expectedError: "expected 5 or 6 fields but got 7"},
+			{spec: "60 * * * *", expectedError: "invalid value for minute"},
+			{spec: "* 24 * * *", expectedError: "invalid value for hour"},
+			{spec: "* * 0 * *", expectedError: "invalid value for day of month"},
+			{spec: "* * * 13 *", expectedError: "invalid value for month"},
+			{spec: "* * * * 8", expectedError: "invalid value for day of week"},
+			{spec: "* * * foo *", expectedError: "invalid value for month"},
+			{spec: "*/0 * * * *", expectedError: "invalid step for minute"},
+			{spec: "5-1 * * * *", expectedError: "invalid range for minute"},
+			{spec: "@every 5m", expectedError: "expected 5 or 6 fields but got 2"},
+			{spec: "@fortnightly", expectedError: "unknown descriptor"},
+			{spec: "CRON_TZ=Not/AZone * * * * *", expectedError: "Not/AZone"},
+		} {
+			_, err := ParseCron(test.spec)
+			tt.AssertErrContains(t, err, "invalid cron spec", test.expectedError)
+		}
+	})
+
+	t.Run("should panic on MustParseCron with an invalid spec", func(t *testing.T) {
+		panicPayload, _ := tt.PanicHandler(func() {
+			MustParseCron("invalid")
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "invalid cron spec")
+	})
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		spec     string
+		from     string
+		expected []string
+	}{
+		{
+			desc: "every 15 minutes on the quarter hour",
+			spec: "TZ=UTC */15 * * * *",
+			from: "2024-01-01T10:07:30Z",
+			expected: []string{
+				"2024-01-01T10:15:00Z",
+				"2024-01-01T10:30:00Z",
+				"2024-01-01T10:45:00Z",
+				"2024-01-01T11:00:00Z",
+			},
+		},
+		{
+			desc: "every day at 02:00",
+			spec: "TZ=UTC 0 2 * * *",
+			from: "2024-01-31T02:00:00Z",
+			expected: []string{
+				"2024-02-01T02:00:00Z",
+				"2024-02-02T02:00:00Z",
+			},
+		},
+		{
+			desc: "with seconds",
+			spec: "TZ=UTC 10,40 * * * * *",
+			from: "2024-01-01T10:00:15Z",
+			expected: []string{
+				"2024-01-01T10:00:40Z",
+				"2024-01-01T10:01:10Z",
+				"2024-01-01T10:01:40Z",
+			},
+		},
+		{
+			desc: "names and ranges on weekdays",
+			spec: "TZ=UTC 30 9 * JAN-feb MON-Fri",
+			from: "2024-02-28T12:00:00Z",
+			expected: []string{
+				"2024-02-29T09:30:00Z",
+				"2025-01-01T09:30:00Z",
+				"2025-01-02T09:30:00Z",
+				"2025-01-03T09:30:00Z",
+				"2025-01-06T09:30:00Z",
+			},
+		},
+		{
+			desc: "either day of month or day of week when both are restricted",
+			spec: "TZ=UTC 0 0 1 * 7",
+			from: "2024-05-29T00:00:00Z",
+			expected: []string{
+				"2024-06-01T00:00:00Z",
+				"2024-06-02T00:00:00Z",
+				"2024-06-09T00:00:00Z",
+			},
+		},
+		{
+			desc: "ranges with steps",
+			spec: "TZ=UTC 0 8-18/5 * * *",
+			from: "2024-01-01T00:00:00Z",
+			expected: []string{
+				"2024-01-01T08:00:00Z",
+				"2024-01-01T13:00:00Z",
+				"2024-01-01T18:00:00Z",
+				"2024-01-02T08:00:00Z",
+			},
+		},
+		{
+			desc: "descriptors",
+			spec: "TZ=UTC @yearly",
+			from: "2024-01-01T00:00:00Z",
+			expected: []string{
+				"2025-01-01T00:00:00Z",
+			},
+		},
+		{
+			desc: "on other time zones",
+			spec: "CRON_TZ=America/Sao_Paulo 0 2 * * *",
+			from: "2024-01-01T00:00:00Z",
+			expected: []string{
+				"2024-01-01T05:00:00Z",
+				"2024-01-02T05:00:00Z",
+			},
+		},
+		{
+			desc: "skipped times run once when the clocks go forward",
+			spec: "CRON_TZ=America/New_York */30 * * * *",
+			from: "2024-03-10T01:00:00-05:00",
+			expected: []string{
+				"2024-03-10T01:30:00-05:00",
+				"2024-03-10T03:00:00-04:00",
+				"2024-03-10T03:30:00-04:00",
+			},
+		},
+		{
+			desc: "daily jobs still run once on the day the clocks go forward",
+			spec: "CRON_TZ=America/New_York 30 2 * * *",
+			from: "2024-03-09T02:30:00-05:00",
+			expected: []string{
+				"2024-03-10T03:00:00-04:00",
+				"2024-03-11T02:30:00-04:00",
+			},
+		},
+		{
+			desc: "repeated times run once when the clocks go back",
+			spec: "CRON_TZ=America/New_York */30 * * * *",
+			from: "2024-11-03T00:45:00-04:00",
+			expected: []string{
+				"2024-11-03T01:00:00-04:00",
+				"2024-11-03T01:30:00-04:00",
+				"2024-11-03T02:00:00-05:00",
+			},
+		},
+		{
+			desc: "repeated times are not run again after the clocks go back",
+			spec: "CRON_TZ=America/New_York */30 * * * *",
+			from: "2024-11-03T01:10:00-05:00",
+			expected: []string{
+				"2024-11-03T02:00:00-05:00",
+			},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			schedule, err := ParseCron(test.spec)
+			tt.AssertNoErr(t, err)
+
+			next := tt.ParseTime(t, test.from)
+			for _, expected := range test.expected {
+				next = schedule.Next(next)
+				tt.AssertEqual(t, next.Equal(tt.ParseTime(t, expected)), true,
+					"expected %v but got %v", expected, next,
+				)
+			}
+		})
+	}
+
+	t.Run("should return the zero time if there are no activations", func(t *testing.T) {
+		schedule := MustParseCron("0 0 30 2 *")
+		tt.AssertEqual(t, schedule.Next(time.Now()).IsZero(), true)
+	})
+}
+
+func TestCronWorker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should run on each activation of the schedule", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			triggerCh <- tt.ParseTime(t, "2024-01-01T10:15:00Z")
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			triggerCh <- tt.ParseTime(t, "2024-01-01T10:30:00Z")
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		var numCalls int
+		err := CronWorker("TZ=UTC */15 * * * *", func(ctx context.Context) error {
+			numCalls++
+			return nil
+		})(ctx)
+
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 2)
+		tt.AssertEqual(t, len(timeAfterArgs), 3)
+		tt.AssertEqual(t, timeAfterArgs[0] > 0 && timeAfterArgs[0] <= 15*time.Minute, true)
+		tt.AssertApproxDuration(t, time.Second, timeAfterArgs[1], 15*time.Minute, "unexpected wait: %v", timeAfterArgs[1])
+		tt.AssertApproxDuration(t, time.Second, timeAfterArgs[2], 15*time.Minute, "unexpected wait: %v", timeAfterArgs[2])
+	})
+
+	t.Run("should retry before resuming the schedule if a retryError is returned", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			<-waitCh
+			triggerCh <- tt.ParseTime(t, "2024-01-01T02:00:00Z")
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			triggerCh <- tt.ParseTime(t, "2024-01-01T02:01:00Z")
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		var numCalls int
+		err := CronWorker(MustParseCron("TZ=UTC 0 2 * * *"), func(ctx context.Context) error {
+			numCalls++
+			if numCalls == 1 {
+				return RetryWorkerIn(time.Minute)
+			}
+			return nil
+		})(ctx)
+
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 2)
+		tt.AssertEqual(t, len(timeAfterArgs), 2)
+		tt.AssertEqual(t, timeAfterArgs[0], time.Minute)
+		tt.AssertApproxDuration(t, time.Second, timeAfterArgs[1], 24*time.Hour-time.Minute, "unexpected wait: %v", timeAfterArgs[1])
+	})
+
+	t.Run("should stop executing if an error is returned", func(t *testing.T) {
+		ctx := ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			<-waitCh
+			triggerCh <- time.Time{}
+		}))
+
+		err := CronWorker("* * * * *", func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})(ctx)
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+
+	t.Run("should return an error if the spec is invalid", func(t *testing.T) {
+		err := CronWorker("invalid", func(ctx context.Context) error {
+			return nil
+		})(ctx)
+		tt.AssertErrContains(t, err, "invalid cron spec")
+	})
+}
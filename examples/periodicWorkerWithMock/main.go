@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/blackpointcyber/threads"
+	"github.com/blackpointcyber/threads/threadstest"
 )
 
 func main() {
@@ -14,23 +15,28 @@ func main() {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	ctx = threads.ContextWithTimeAfterMock(ctx, func(triggerCh chan time.Time, waitCh chan time.Duration) {
-		<-waitCh                 // Wait until time.After is called
-		triggerCh <- time.Time{} // Makes <-time.After return
-		<-waitCh                 // Waits again
-		cancel()                 // Forces the worker to stop:
-	})
+	// A clock that only moves when told to:
+	clock := threadstest.NewFakeClock(time.Now())
+	ctx = clock.Context(ctx)
 
 	g := threads.NewGroup(ctx)
 
 	// A worker that runs immediately at start and
-	// then once again when triggerCh receives a message:
+	// then once again each time the clock is advanced:
+	runCh := make(chan int)
 	count := 0
 	g.Go(threads.PeriodicWorker(1*time.Hour, func(ctx context.Context) error {
 		count++
 		fmt.Printf("Run count: %v\n", count)
+		runCh <- count
 		return nil
 	}))
 
+	<-runCh
+	clock.BlockUntilWaiters(1) // Waits until the worker is sleeping
+	clock.Advance(1 * time.Hour)
+	<-runCh
+
+	cancel() // Forces the worker to stop
 	g.Wait()
 }
@@ -0,0 +1,163 @@
+// Package goroutines inspects the running goroutines and keeps
+// track of the ones started by the threads library, so that the
+// threadstest package can report the ones that leak.
+package goroutines
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CurrentID returns the id of the calling goroutine.
+func CurrentID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	return parseID(buf)
+}
+
+// parseID parses the header of a goroutine stack,
+// which looks like: "goroutine 42 [running]:"
+func parseID(stack []byte) int64 {
+	fields := bytes.Fields(stack)
+	if len(fields) < 2 {
+		return 0
+	}
+
+	id, _ := strconv.ParseInt(string(fields[1]), 10, 64)
+	return id
+}
+
+// WaitingOnChannel reports whether the goroutine of the stack is blocked
+// receiving from a channel or on a select, e.g. waiting for a timer, the
+// header of the stack then looks like: "goroutine 42 [select, 2 minutes]:"
+func WaitingOnChannel(stack string) bool {
+	start := strings.IndexByte(stack, '[')
+	end := strings.IndexByte(stack, ']')
+	if start < 0 || end < start {
+		return false
+	}
+
+	state := stack[start+1 : end]
+	return strings.HasPrefix(state, "chan receive") || strings.HasPrefix(state, "select")
+}
+
+// Stacks returns the stacks of all the running goroutines
+// indexed by their ids, or only the ones with the given
+// ids if filter is not nil.
+func Stacks(filter map[int64]bool) map[int64]string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := map[int64]string{}
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		id := parseID(stack)
+		if filter == nil || filter[id] {
+			stacks[id] = string(stack)
+		}
+	}
+	return stacks
+}
+
+var (
+	// The number of callers that enabled the tracking:
+	numTrackers int32
+
+	trackedMux sync.Mutex
+	tracked    = map[*Goroutine]bool{}
+)
+
+// EnableTracking makes Track register the goroutines
+// until the returned function is called.
+func EnableTracking() (disable func()) {
+	atomic.AddInt32(&numTrackers, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt32(&numTrackers, -1)
+		})
+	}
+}
+
+// Tracking reports whether the tracking is enabled, so the callers
+// can skip building the label of the goroutine if it isn't.
+func Tracking() bool {
+	return atomic.LoadInt32(&numTrackers) > 0
+}
+
+// Goroutine is a goroutine registered with Track,
+// its methods are safe to call on a nil *Goroutine.
+type Goroutine struct {
+	label string
+	id    int64
+}
+
+// Track registers a goroutine that is about to be started, doing it
+// before the go statement ensures the goroutine is tracked even if
+// it didn't get the chance to run yet.
+//
+// It returns nil if the tracking is not enabled.
+func Track(label string) *Goroutine {
+	if !Tracking() {
+		return nil
+	}
+
+	g := &Goroutine{label: label}
+
+	trackedMux.Lock()
+	tracked[g] = true
+	trackedMux.Unlock()
+
+	return g
+}
+
+// Started must be called by the tracked goroutine as soon as it
+// starts, and the returned function right before it returns:
+//
+//	defer g.Started()()
+func (g *Goroutine) Started() (exited func()) {
+	if g == nil {
+		return func() {}
+	}
+
+	id := CurrentID()
+	trackedMux.Lock()
+	g.id = id
+	trackedMux.Unlock()
+
+	return func() {
+		trackedMux.Lock()
+		delete(tracked, g)
+		trackedMux.Unlock()
+	}
+}
+
+// Info describes a tracked goroutine, the id is 0
+// if the goroutine didn't start running yet.
+type Info struct {
+	Label string
+	ID    int64
+}
+
+// Tracked returns the tracked goroutines that are still running.
+func Tracked() map[*Goroutine]Info {
+	trackedMux.Lock()
+	defer trackedMux.Unlock()
+
+	running := make(map[*Goroutine]Info, len(tracked))
+	for g := range tracked {
+		running[g] = Info{Label: g.label, ID: g.id}
+	}
+	return running
+}
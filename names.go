@@ -0,0 +1,75 @@
+package threads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type ctxWorkerNameKey struct{}
+
+// WorkerName returns the name of the worker that received this
+// context, or an empty string if the worker was not named.
+//
+// Names are hierarchical, so a worker named "parser" started
+// inside a worker named "ingest" will be named "ingest/parser".
+func WorkerName(ctx context.Context) string {
+	name, _ := ctx.Value(ctxWorkerNameKey{}).(string)
+	return name
+}
+
+// WorkerError wraps the errors returned by named workers
+// so it is possible to tell which of the workers failed.
+type WorkerError struct {
+	WorkerName string
+	Err        error
+}
+
+func (w *WorkerError) Error() string {
+	return fmt.Sprintf("worker %s: %s", w.WorkerName, w.Err)
+}
+
+func (w *WorkerError) Unwrap() error {
+	return w.Err
+}
+
+// wrapWorkerError wraps the error unless it was already wrapped
+// by a named worker, which is the case when errors propagate
+// from a subgroup, since the innermost name is the most useful one.
+func wrapWorkerError(name string, err error) error {
+	var workerErr *WorkerError
+	if errors.As(err, &workerErr) {
+		return err
+	}
+
+	return &WorkerError{
+		WorkerName: name,
+		Err:        err,
+	}
+}
+
+func workerFullName(parentCtx context.Context, name string, workerID int) string {
+	parentName := WorkerName(parentCtx)
+	if name == "" {
+		// Unnamed workers are only named if their parent is:
+		if parentName == "" {
+			return ""
+		}
+		name = strconv.Itoa(workerID)
+	}
+
+	if parentName == "" {
+		return name
+	}
+	return parentName + "/" + name
+}
+
+// workerLabel identifies the goroutine of a worker
+// on the leak reports of the threadstest package.
+func workerLabel(name string, workerID int) string {
+	if name == "" {
+		return "worker " + strconv.Itoa(workerID)
+	}
+	return "worker " + name
+}
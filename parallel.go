@@ -0,0 +1,197 @@
+package threads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var ErrItemsNotProcessed = fmt.Errorf("not all items were processed")
+
+// Map calls fn for each of the items with at most concurrency calls
+// running at the same time and returns the outputs in the same order
+// as the input items, a concurrency of 0 means no limit.
+//
+// The first error cancels the remaining calls and is returned, panics
+// are forwarded to the caller just like it happens with Group.Wait.
+//
+// If some of the items were not processed without any errors, e.g.
+// because ctx was canceled or fn returned ErrStartGracefulShutdown,
+// it returns an error wrapping ErrItemsNotProcessed and ctx.Err(),
+// if any, instead of the partial outputs.
+func Map[In any, Out any](
+	ctx context.Context,
+	items []In,
+	concurrency int,
+	fn func(ctx context.Context, item In) (Out, error),
+) ([]Out, error) {
+	var numDone int32
+	g := NewResultGroup[Out](ctx, WithLimit(concurrency))
+	for _, item := range items {
+		// Stop starting new workers once one of them fails:
+		if g.g.Context().Err() != nil {
+			break
+		}
+
+		item := item
+		g.Go(func(ctx context.Context) (Out, error) {
+			out, err := fn(ctx, item)
+			if err == nil {
+				atomic.AddInt32(&numDone, 1)
+			}
+			return out, err
+		})
+	}
+
+	outputs, err := g.Wait()
+	if err != nil && !errors.Is(err, ErrWorkersNotStarted) {
+		return nil, err
+	}
+	if err := notProcessedErr(ctx, len(items), numDone); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// ForEach works like Map for functions that produce no outputs.
+func ForEach[In any](
+	ctx context.Context,
+	items []In,
+	concurrency int,
+	fn func(ctx context.Context, item In) error,
+) error {
+	var numDone int32
+	g := NewGroup(ctx, WithLimit(concurrency))
+	for _, item := range items {
+		if g.Context().Err() != nil {
+			break
+		}
+
+		item := item
+		g.Go(func(ctx context.Context) error {
+			err := fn(ctx, item)
+			if err == nil {
+				atomic.AddInt32(&numDone, 1)
+			}
+			return err
+		})
+	}
+
+	err := g.Wait()
+	if err != nil && !errors.Is(err, ErrWorkersNotStarted) {
+		return err
+	}
+	return notProcessedErr(ctx, len(items), numDone)
+}
+
+// notProcessedErr returns an error if not all the items were processed,
+// it must only be called after all the calls to fn have returned.
+func notProcessedErr(ctx context.Context, numItems int, numDone int32) error {
+	if int(numDone) == numItems {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: %d of %d items were skipped", ErrItemsNotProcessed, numItems-int(numDone), numItems)
+	if ctx.Err() != nil {
+		err = fmt.Errorf("%w: %w", err, ctx.Err())
+	}
+	return err
+}
+
+// MapStream calls fn for each item received on the input channel with at
+// most concurrency calls running at the same time and sends the outputs to
+// the returned channel in the order they are produced, just like with Map a
+// concurrency of 0 means no limit.
+//
+// The output channel is closed once the input channel is closed and all
+// items were processed or after the first error, the caller should then call
+// the returned wait function to get the error and to receive any panics.
+func MapStream[In any, Out any](
+	ctx context.Context,
+	in <-chan In,
+	concurrency int,
+	fn func(ctx context.Context, item In) (Out, error),
+) (out <-chan Out, wait func() error) {
+	outCh := make(chan Out)
+	g := startStreamWorkers(ctx, in, concurrency, func(ctx context.Context, item In) error {
+		v, err := fn(ctx, item)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case outCh <- v:
+		case <-ctx.Done():
+		}
+		return nil
+	}, func() {
+		close(outCh)
+	})
+
+	return outCh, g.Wait
+}
+
+// ForEachStream works like MapStream for functions that produce no outputs,
+// it waits until the input channel is closed and all items were processed
+// or until the first error.
+func ForEachStream[In any](
+	ctx context.Context,
+	in <-chan In,
+	concurrency int,
+	fn func(ctx context.Context, item In) error,
+) error {
+	g := startStreamWorkers(ctx, in, concurrency, fn, func() {})
+	return g.Wait()
+}
+
+func startStreamWorkers[In any](
+	ctx context.Context,
+	in <-chan In,
+	concurrency int,
+	fn func(ctx context.Context, item In) error,
+	onDone func(),
+) *Group {
+	// The items are processed on a group of their own, since the
+	// Group is waited by the caller while new items are still
+	// arriving, and Go can't be called concurrently with Wait:
+	g := NewGroup(ctx)
+	g.Go(func(ctx context.Context) error {
+		defer onDone()
+
+		items := NewGroup(ctx, WithLimit(concurrency), WithPanicAsError())
+		receiveItems(&items, in, fn)
+
+		err := items.Wait()
+		if panicErr, ok := err.(*PanicError); ok {
+			panic(subGroupPanic{panicErr})
+		}
+		return err
+	})
+
+	return &g
+}
+
+// receiveItems starts a worker for each item received on the input
+// channel until it is closed or until the group is canceled.
+func receiveItems[In any](g *Group, in <-chan In, fn func(ctx context.Context, item In) error) {
+	for {
+		select {
+		case <-g.Context().Done():
+			return
+		case item, ok := <-in:
+			if !ok {
+				return
+			}
+
+			g.Go(func(ctx context.Context) error {
+				err := fn(ctx, item)
+				if errors.Is(err, ErrRestartGroup) {
+					// Restarting would require replaying the items already consumed:
+					return fmt.Errorf("ErrRestartGroup is not supported by stream workers: %s", err)
+				}
+				return err
+			})
+		}
+	}
+}
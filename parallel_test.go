@@ -0,0 +1,266 @@
+package threads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestMap(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return the outputs in the same order as the inputs", func(t *testing.T) {
+		var counter concurrencyCounter
+		outputs, err := Map(ctx, []int{3, 2, 1, 0}, 2, func(ctx context.Context, item int) (string, error) {
+			defer counter.enter()()
+			time.Sleep(time.Duration(item) * 100 * time.Microsecond)
+			return fmt.Sprint(item), nil
+		})
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, outputs, []string{"3", "2", "1", "0"})
+		tt.AssertEqual(t, counter.max() <= 2, true)
+	})
+
+	t.Run("should stop at the first error", func(t *testing.T) {
+		var numCalls int32
+		_, err := Map(ctx, []int{1, 2, 3, 4, 5}, 1, func(ctx context.Context, item int) (int, error) {
+			atomic.AddInt32(&numCalls, 1)
+			if item == 2 {
+				return 0, fmt.Errorf("fakeErrMsg")
+			}
+			return item, nil
+		})
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls) < 5, true)
+	})
+
+	t.Run("should not return partial outputs if the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		outputs, err := Map(ctx, []int{1, 2, 3, 4}, 1, func(ctx context.Context, item int) (int, error) {
+			cancel()
+			return item * 10, nil
+		})
+		tt.AssertEqual(t, errors.Is(err, ErrItemsNotProcessed), true, err)
+		tt.AssertEqual(t, errors.Is(err, context.Canceled), true, err)
+		tt.AssertEqual(t, outputs, []int(nil))
+	})
+
+	t.Run("should report the items skipped after ErrStartGracefulShutdown", func(t *testing.T) {
+		outputs, err := Map(ctx, []int{1, 2, 3}, 1, func(ctx context.Context, item int) (int, error) {
+			if item == 2 {
+				return 0, ErrStartGracefulShutdown
+			}
+			return item, nil
+		})
+		tt.AssertEqual(t, errors.Is(err, ErrItemsNotProcessed), true, err)
+		tt.AssertEqual(t, outputs, []int(nil))
+	})
+
+	t.Run("should forward panics to the caller", func(t *testing.T) {
+		panicPayload, _ := tt.PanicHandler(func() {
+			Map(ctx, []int{1}, 0, func(ctx context.Context, item int) (int, error) {
+				panic("fakePanicPayload")
+			})
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakePanicPayload")
+	})
+}
+
+func TestForEach(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should call the function for every item", func(t *testing.T) {
+		var counter concurrencyCounter
+		var sum int32
+		err := ForEach(ctx, []int32{1, 2, 3, 4}, 2, func(ctx context.Context, item int32) error {
+			defer counter.enter()()
+			time.Sleep(100 * time.Microsecond)
+			atomic.AddInt32(&sum, item)
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&sum), int32(10))
+		tt.AssertEqual(t, counter.max() <= 2, true)
+	})
+
+	t.Run("should report the skipped items if the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var numCalls int32
+		err := ForEach(ctx, []int{1, 2, 3, 4}, 1, func(ctx context.Context, item int) error {
+			atomic.AddInt32(&numCalls, 1)
+			cancel()
+			return nil
+		})
+		tt.AssertEqual(t, errors.Is(err, ErrItemsNotProcessed), true, err)
+		tt.AssertEqual(t, errors.Is(err, context.Canceled), true, err)
+		tt.AssertErrContains(t, err, fmt.Sprintf("%d of 4 items were skipped", 4-atomic.LoadInt32(&numCalls)))
+	})
+
+	t.Run("should return the first error", func(t *testing.T) {
+		err := ForEach(ctx, []int{1, 2, 3}, 0, func(ctx context.Context, item int) error {
+			if item == 2 {
+				return fmt.Errorf("fakeErrMsg")
+			}
+			return nil
+		})
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+}
+
+func TestMapStream(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should process every item of the input channel", func(t *testing.T) {
+		in := make(chan int)
+		go func() {
+			defer close(in)
+			for i := 0; i < 10; i++ {
+				in <- i
+			}
+		}()
+
+		out, wait := MapStream(ctx, in, 3, func(ctx context.Context, item int) (int, error) {
+			return item * 2, nil
+		})
+
+		var outputs []int
+		for v := range out {
+			outputs = append(outputs, v)
+		}
+		err := wait()
+		tt.AssertNoErr(t, err)
+
+		sort.Ints(outputs)
+		tt.AssertEqual(t, outputs, []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18})
+	})
+
+	t.Run("should close the output channel and report the first error", func(t *testing.T) {
+		in := make(chan int, 10)
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+
+		out, wait := MapStream(ctx, in, 2, func(ctx context.Context, item int) (int, error) {
+			if item == 3 {
+				return 0, fmt.Errorf("fakeErrMsg")
+			}
+			return item, nil
+		})
+
+		for range out {
+		}
+		err := wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+
+	t.Run("should forward panics when wait is called", func(t *testing.T) {
+		in := make(chan int, 1)
+		in <- 1
+
+		out, wait := MapStream(ctx, in, 1, func(ctx context.Context, item int) (int, error) {
+			panic("fakePanicPayload")
+		})
+
+		for range out {
+		}
+		panicPayload, _ := tt.PanicHandler(func() {
+			wait()
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakePanicPayload")
+	})
+}
+
+func TestForEachStream(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should process items until the input channel is closed", func(t *testing.T) {
+		in := make(chan int32, 4)
+		in <- 1
+		in <- 2
+		in <- 3
+		in <- 4
+		close(in)
+
+		var sum int32
+		err := ForEachStream(ctx, in, 2, func(ctx context.Context, item int32) error {
+			atomic.AddInt32(&sum, item)
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&sum), int32(10))
+	})
+
+	t.Run("should not limit the concurrency if it is 0", func(t *testing.T) {
+		const numItems = 5
+		in := make(chan int, numItems)
+		for i := 0; i < numItems; i++ {
+			in <- i
+		}
+		close(in)
+
+		// Every call blocks until all of them have started:
+		var numStarted int32
+		allStartedCh := make(chan struct{})
+		err := ForEachStream(ctx, in, 0, func(ctx context.Context, item int) error {
+			if atomic.AddInt32(&numStarted, 1) == numItems {
+				close(allStartedCh)
+			}
+
+			select {
+			case <-allStartedCh:
+				return nil
+			case <-time.After(time.Second):
+				return fmt.Errorf("only %d calls started", atomic.LoadInt32(&numStarted))
+			}
+		})
+		tt.AssertNoErr(t, err)
+	})
+
+	t.Run("should not run more than concurrency calls at the same time", func(t *testing.T) {
+		in := make(chan int, 10)
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+		close(in)
+
+		var counter concurrencyCounter
+		err := ForEachStream(ctx, in, 2, func(ctx context.Context, item int) error {
+			defer counter.enter()()
+			time.Sleep(100 * time.Microsecond)
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, counter.max() <= 2, true)
+	})
+
+	t.Run("should stop when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := ForEachStream(ctx, make(chan int), 2, func(ctx context.Context, item int) error {
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+	})
+
+	t.Run("should not restart the workers on ErrRestartGroup", func(t *testing.T) {
+		in := make(chan int, 1)
+		in <- 1
+
+		err := ForEachStream(ctx, in, 1, func(ctx context.Context, item int) error {
+			return ErrRestartGroup
+		})
+		tt.AssertErrContains(t, err, "not supported by stream workers")
+		tt.AssertEqual(t, errors.Is(err, ErrRestartGroup), false)
+	})
+}
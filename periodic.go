@@ -0,0 +1,192 @@
+package threads
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/blackpointcyber/threads/safe"
+)
+
+// Periodic controls a worker created with NewPeriodic,
+// all of its methods are safe for concurrent use.
+type Periodic struct {
+	mux sync.Mutex
+
+	triggered bool
+	paused    bool
+	interval  time.Duration
+
+	lastRun time.Time
+	nextRun time.Time
+
+	// Receives a message when any of the fields above change:
+	wakeCh chan struct{}
+}
+
+// NewPeriodic works like PeriodicWorker but also returns a *Periodic
+// that can be used for controlling the worker from outside of it, e.g.
+// for running an iteration right away:
+//
+//	periodic, worker := threads.NewPeriodic(time.Hour, refreshCache)
+//	g.Go(worker)
+//
+//	// On an admin request:
+//	periodic.Trigger()
+func NewPeriodic[T intervalType](
+	interval T,
+	doWork Worker,
+	opts ...PeriodicOption,
+) (*Periodic, Worker) {
+	p := &Periodic{
+		wakeCh: make(chan struct{}, 1),
+	}
+
+	opts = append(opts, func(cfg *periodicConfig) {
+		cfg.ctrl = p
+	})
+	return p, PeriodicWorker(interval, doWork, opts...)
+}
+
+// Trigger makes the worker run an iteration immediately, even if it
+// is paused, and then continue on its normal schedule from there.
+//
+// If an iteration is running the triggered one runs right after it,
+// and several calls before the iteration starts run it only once.
+func (p *Periodic) Trigger() {
+	p.update(func() {
+		p.triggered = true
+	})
+}
+
+// Pause stops the worker from running new iterations until Resume
+// is called, an iteration that is already running is not interrupted.
+func (p *Periodic) Pause() {
+	p.update(func() {
+		p.paused = true
+	})
+}
+
+// Resume makes a paused worker go back to its normal schedule,
+// if any iterations were missed while paused one runs immediately.
+func (p *Periodic) Resume() {
+	p.update(func() {
+		p.paused = false
+	})
+}
+
+// SetInterval changes the interval of the worker just like if doWork
+// returned AdjustInterval(d), but it also reschedules the current wait,
+// so the next iteration happens d after the end of the last one, or
+// after its start if WithFixedRate is used.
+func (p *Periodic) SetInterval(d time.Duration) {
+	p.update(func() {
+		p.interval = d
+	})
+}
+
+// LastRun returns when the last iteration started,
+// or the zero time if no iterations ran yet.
+func (p *Periodic) LastRun() time.Time {
+	return safe.Get(&p.mux, &p.lastRun)
+}
+
+// NextRun returns when the next iteration is scheduled to start, or
+// the zero time if the worker is paused, not started or running an
+// iteration.
+func (p *Periodic) NextRun() time.Time {
+	return safe.Get(&p.mux, &p.nextRun)
+}
+
+func (p *Periodic) update(fn func()) {
+	safe.Do(&p.mux, fn)
+
+	select {
+	case p.wakeCh <- struct{}{}:
+	default:
+		// The worker will already wake up.
+	}
+}
+
+func (p *Periodic) started(t time.Time) {
+	safe.Do(&p.mux, func() {
+		p.triggered = false
+		p.lastRun = t
+		p.nextRun = time.Time{}
+	})
+}
+
+// waitResumed blocks while the worker is paused and returns
+// false if the context is cancelled while waiting.
+func (p *Periodic) waitResumed(ctx context.Context) bool {
+	for {
+		var ready bool
+		safe.Do(&p.mux, func() {
+			ready = p.triggered || !p.paused
+		})
+		if ready {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-p.wakeCh:
+		}
+	}
+}
+
+// wait works like periodicConfig.sleep but also handles the
+// changes made using the methods of Periodic.
+func (p *Periodic) wait(
+	ctx context.Context,
+	clock Clock,
+	wait time.Duration,
+	base time.Time,
+) (wake wakeUp, ok bool) {
+	deadline := clock.Now().Add(wait)
+	timer := clock.After(wait)
+	fired := false
+	for {
+		var triggered, paused bool
+		var interval time.Duration
+		safe.Do(&p.mux, func() {
+			triggered, paused, interval = p.triggered, p.paused, p.interval
+			p.interval = 0
+		})
+
+		if triggered {
+			wake.triggered = true
+			wake.now = clock.Now()
+			return wake, true
+		}
+
+		if interval > 0 {
+			wake.interval = interval
+			deadline = base.Add(interval)
+			timer = clock.After(deadline.Sub(clock.Now()))
+			fired = false
+		}
+
+		if fired && !paused {
+			return wake, true
+		}
+
+		nextRun := deadline
+		if paused {
+			nextRun = time.Time{}
+		}
+		safe.Set(&p.mux, &p.nextRun, nextRun)
+
+		select {
+		case <-ctx.Done():
+			return wake, false
+		case wake.now = <-timer:
+			// Receiving from a nil channel blocks forever, so it
+			// doesn't fire again while waiting for Resume:
+			timer = nil
+			fired = true
+		case <-p.wakeCh:
+		}
+	}
+}
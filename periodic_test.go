@@ -0,0 +1,200 @@
+package threads
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestPeriodic(t *testing.T) {
+	ctx := context.Background()
+
+	// startPeriodic starts the worker in the background and returns
+	// a channel that receives a message on each iteration and a
+	// function that stops the worker and waits for it to return.
+	startPeriodic := func(interval time.Duration, opts ...PeriodicOption) (*Periodic, chan struct{}, func()) {
+		ctx, cancel := context.WithCancel(ctx)
+		runCh := make(chan struct{}, 100)
+
+		periodic, worker := NewPeriodic(interval, func(ctx context.Context) error {
+			runCh <- struct{}{}
+			return nil
+		}, opts...)
+
+		doneCh := make(chan struct{})
+		go func() {
+			defer close(doneCh)
+			worker(ctx)
+		}()
+
+		return periodic, runCh, func() {
+			cancel()
+			<-doneCh
+		}
+	}
+
+	// waitScheduled waits until the worker is waiting for the next iteration.
+	waitScheduled := func(periodic *Periodic) time.Time {
+		for {
+			if next := periodic.NextRun(); !next.IsZero() {
+				return next
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+
+	assertNoRun := func(t *testing.T, runCh chan struct{}) {
+		select {
+		case <-runCh:
+			t.Fatalf("no iterations were expected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	t.Run("should run an iteration immediately when triggered", func(t *testing.T) {
+		periodic, runCh, stop := startPeriodic(time.Hour)
+		defer stop()
+
+		tt.AssertEqual(t, periodic.LastRun().IsZero(), true)
+
+		<-runCh
+		firstRun := periodic.LastRun()
+		tt.AssertEqual(t, firstRun.IsZero(), false)
+
+		nextRun := waitScheduled(periodic)
+		tt.AssertApproxTime(t, time.Second, nextRun, firstRun.Add(time.Hour), "unexpected next run: %v", nextRun)
+
+		periodic.Trigger()
+		<-runCh
+		tt.AssertEqual(t, periodic.LastRun().After(firstRun), true)
+
+		// And then goes back to the normal schedule:
+		nextRun = waitScheduled(periodic)
+		tt.AssertApproxTime(t, time.Second, nextRun, periodic.LastRun().Add(time.Hour), "unexpected next run: %v", nextRun)
+		assertNoRun(t, runCh)
+	})
+
+	t.Run("should not run iterations while paused", func(t *testing.T) {
+		periodic, runCh, stop := startPeriodic(time.Millisecond)
+		defer stop()
+
+		periodic.Pause()
+
+		// Discards any iteration that started before the pause:
+	drain:
+		for {
+			select {
+			case <-runCh:
+			case <-time.After(5 * time.Millisecond):
+				break drain
+			}
+		}
+		assertNoRun(t, runCh)
+		tt.AssertEqual(t, periodic.NextRun().IsZero(), true)
+
+		periodic.Resume()
+		<-runCh
+		<-runCh
+	})
+
+	t.Run("should not start the first iteration while paused", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		runCh := make(chan struct{}, 10)
+		periodic, worker := NewPeriodic(time.Hour, func(ctx context.Context) error {
+			runCh <- struct{}{}
+			return nil
+		})
+		periodic.Pause()
+		go worker(ctx)
+
+		assertNoRun(t, runCh)
+
+		// Triggering runs a single iteration even when paused:
+		periodic.Trigger()
+		<-runCh
+		assertNoRun(t, runCh)
+		tt.AssertEqual(t, periodic.NextRun().IsZero(), true)
+
+		periodic.Resume()
+		nextRun := waitScheduled(periodic)
+		tt.AssertApproxTime(t, time.Second, nextRun, periodic.LastRun().Add(time.Hour), "unexpected next run: %v", nextRun)
+	})
+
+	t.Run("should reschedule the next iteration when the interval changes", func(t *testing.T) {
+		periodic, runCh, stop := startPeriodic(time.Hour)
+		defer stop()
+
+		<-runCh
+		waitScheduled(periodic)
+
+		periodic.SetInterval(time.Minute)
+		for {
+			nextRun := periodic.NextRun()
+			if nextRun.Before(time.Now().Add(2 * time.Minute)) {
+				tt.AssertApproxTime(t, time.Second, nextRun, periodic.LastRun().Add(time.Minute), "unexpected next run: %v", nextRun)
+				break
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+
+		periodic.SetInterval(time.Millisecond)
+		<-runCh
+		<-runCh
+	})
+
+	t.Run("should run immediately when triggered on fixed-rate mode", func(t *testing.T) {
+		periodic, runCh, stop := startPeriodic(time.Hour, WithFixedRate(OverrunSkip))
+		defer stop()
+
+		<-runCh
+		waitScheduled(periodic)
+
+		periodic.Trigger()
+		<-runCh
+
+		nextRun := waitScheduled(periodic)
+		tt.AssertApproxTime(t, time.Second, nextRun, periodic.LastRun().Add(time.Hour), "unexpected next run: %v", nextRun)
+	})
+
+	t.Run("should be safe for concurrent use", func(t *testing.T) {
+		var numRuns int32
+		ctx, cancel := context.WithCancel(ctx)
+		periodic, worker := NewPeriodic(time.Millisecond, func(ctx context.Context) error {
+			atomic.AddInt32(&numRuns, 1)
+			return nil
+		})
+
+		doneCh := make(chan struct{})
+		go func() {
+			defer close(doneCh)
+			worker(ctx)
+		}()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					periodic.Trigger()
+					periodic.Pause()
+					periodic.SetInterval(time.Duration(j+1) * time.Microsecond)
+					periodic.Resume()
+					periodic.LastRun()
+					periodic.NextRun()
+				}
+			}()
+		}
+		wg.Wait()
+
+		cancel()
+		<-doneCh
+		tt.AssertEqual(t, atomic.LoadInt32(&numRuns) > 0, true)
+	})
+}
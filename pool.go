@@ -0,0 +1,602 @@
+package threads
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blackpointcyber/threads/internal/goroutines"
+	"github.com/blackpointcyber/threads/safe"
+)
+
+var ErrQueueFull = fmt.Errorf("the pool queue is full")
+var ErrPoolClosed = fmt.Errorf("the pool is closed")
+
+// Job is a unit of work submitted to a Pool.
+type Job func(ctx context.Context) error
+
+// Pool runs a fixed number of workers inside a Group
+// that execute the jobs received via Submit.
+//
+// Call Close when no more jobs will be submitted and then
+// Wait for the workers to return, just like with a Group.
+type Pool struct {
+	g     Group
+	queue chan *queuedJob
+	clock Clock
+
+	// Closed when Close is called so Submit stops accepting jobs:
+	closing chan struct{}
+	// Closed after no more jobs can be added to the queue:
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// Closed when a job error stops the pool, see WithStopOnJobError:
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// Held by Submit while sending jobs so Close can
+	// wait for them before signaling the workers:
+	submitMux sync.RWMutex
+
+	numWorkers int32
+
+	// The jobs waiting for a worker, including the ones blocked on
+	// Submit, only tracked for measuring the latency of the queue
+	// when the pool is autoscaling:
+	waitingMux sync.Mutex
+	waiting    map[*queuedJob]struct{}
+
+	minWorkers int
+	cfg        poolConfig
+}
+
+type poolConfig struct {
+	queueSize       int
+	rejectWhenFull  bool
+	dropOnClose     bool
+	stopOnJobError  bool
+	jobErrorHandler func(err error)
+	groupOptions    []GroupOption
+
+	maxWorkers     int
+	idleTimeout    time.Duration
+	scaleInterval  time.Duration
+	scaleUpLatency time.Duration
+}
+
+type queuedJob struct {
+	job      Job
+	queuedAt time.Time
+}
+
+// PoolOption configures optional behaviors of a Pool,
+// it should be passed as an argument to NewPool.
+type PoolOption func(cfg *poolConfig)
+
+// WithQueueSize sets how many jobs can wait on the queue,
+// by default it is the same as the number of workers.
+func WithQueueSize(n int) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.queueSize = n
+	}
+}
+
+// WithRejectWhenFull makes Submit return ErrQueueFull
+// instead of blocking when the queue is full.
+func WithRejectWhenFull() PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.rejectWhenFull = true
+	}
+}
+
+// WithDropOnClose makes the workers discard the jobs still on the
+// queue when Close is called, by default they are executed first.
+func WithDropOnClose() PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.dropOnClose = true
+	}
+}
+
+// WithJobErrorHandler sets a function to be called
+// with the errors returned by each of the jobs.
+func WithJobErrorHandler(fn func(err error)) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.jobErrorHandler = fn
+	}
+}
+
+// WithStopOnJobError makes the pool stop on the first job error,
+// which is then returned by Pool.Wait, by default the pool keeps
+// running and the errors are only sent to the WithJobErrorHandler.
+func WithStopOnJobError() PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.stopOnJobError = true
+	}
+}
+
+// WithAutoscaling makes the pool start new workers while there are
+// jobs waiting on the queue, up to maxWorkers, and stop the extra ones
+// once they have been idle for a while, see WithIdleTimeout and
+// WithScaleUpLatency.
+//
+// The number of workers passed to NewPool becomes the minimum number
+// of workers and the queue size defaults to maxWorkers.
+func WithAutoscaling(maxWorkers int) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.maxWorkers = maxWorkers
+	}
+}
+
+// WithIdleTimeout sets for how long each worker needs to be idle
+// before the autoscaling pool stops it, the default is 1 minute.
+func WithIdleTimeout(d time.Duration) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.idleTimeout = d
+	}
+}
+
+// WithScaleInterval sets how often the autoscaling pool checks if it
+// should start or stop workers, the default is 1 second.
+func WithScaleInterval(d time.Duration) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.scaleInterval = d
+	}
+}
+
+// WithScaleUpLatency sets for how long the oldest job needs to be waiting
+// for a worker before the autoscaling pool starts new ones, the default is
+// 0, which starts them as soon as there are jobs waiting.
+//
+// The latency is measured with the Clock of the context passed to NewPool
+// and checked once per scale interval, so a job might wait up to the
+// latency plus the scale interval before a new worker is started.
+func WithScaleUpLatency(d time.Duration) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.scaleUpLatency = d
+	}
+}
+
+// WithGroupOptions sets the options of the Group used by the pool.
+func WithGroupOptions(opts ...GroupOption) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.groupOptions = append(cfg.groupOptions, opts...)
+	}
+}
+
+// NewPool creates a Pool and starts its workers immediately.
+func NewPool(ctx context.Context, numWorkers int, opts ...PoolOption) *Pool {
+	cfg := poolConfig{
+		queueSize:     -1,
+		idleTimeout:   time.Minute,
+		scaleInterval: time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.queueSize < 0 {
+		cfg.queueSize = numWorkers
+		if cfg.maxWorkers > numWorkers {
+			cfg.queueSize = cfg.maxWorkers
+		}
+	}
+
+	p := &Pool{
+		queue:      make(chan *queuedJob, cfg.queueSize),
+		clock:      ClockFromContext(ctx),
+		closing:    make(chan struct{}),
+		closed:     make(chan struct{}),
+		stopped:    make(chan struct{}),
+		minWorkers: numWorkers,
+		cfg:        cfg,
+	}
+
+	p.g = NewGroup(ctx, cfg.groupOptions...)
+	if cfg.maxWorkers > 0 {
+		p.numWorkers = int32(numWorkers)
+		p.waiting = map[*queuedJob]struct{}{}
+		p.g.Go(p.scaler)
+		return p
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		p.g.Go(p.worker)
+	}
+
+	return p
+}
+
+// Submit adds a job to the queue, blocking while the queue is full
+// unless the WithRejectWhenFull option is set.
+//
+// It returns ErrPoolClosed if the pool was closed or stopped and
+// ctx.Err() if ctx is canceled while waiting.
+func (p *Pool) Submit(ctx context.Context, job Job) error {
+	p.submitMux.RLock()
+	defer p.submitMux.RUnlock()
+
+	// Checked before sending since select picks a random case when more
+	// than one is ready, and a stopped pool would never run the job:
+	if p.isStopped() {
+		return ErrPoolClosed
+	}
+
+	queued := p.startWaiting(job)
+	err := p.send(ctx, queued)
+	if err != nil {
+		p.stopWaiting(queued)
+	}
+	return err
+}
+
+func (p *Pool) send(ctx context.Context, queued *queuedJob) error {
+	if p.cfg.rejectWhenFull {
+		select {
+		case p.queue <- queued:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case p.queue <- queued:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.closing:
+		return ErrPoolClosed
+	case <-p.stopped:
+		return ErrPoolClosed
+	case <-p.g.Context().Done():
+		return ErrPoolClosed
+	}
+}
+
+// isStopped reports whether the pool was closed or its workers are
+// stopping, either because of a job error or because the context of
+// the pool was canceled.
+func (p *Pool) isStopped() bool {
+	select {
+	case <-p.closing:
+		return true
+	case <-p.stopped:
+		return true
+	default:
+	}
+
+	return p.g.Context().Err() != nil
+}
+
+// startWaiting wraps the job for the queue, registering
+// when it started waiting if the pool is autoscaling.
+func (p *Pool) startWaiting(job Job) *queuedJob {
+	queued := &queuedJob{job: job}
+	if p.waiting == nil {
+		return queued
+	}
+
+	queued.queuedAt = p.clock.Now()
+	p.waitingMux.Lock()
+	defer p.waitingMux.Unlock()
+	p.waiting[queued] = struct{}{}
+	return queued
+}
+
+// stopWaiting is called once the job was picked by a
+// worker or if it couldn't be added to the queue.
+func (p *Pool) stopWaiting(queued *queuedJob) {
+	if p.waiting == nil {
+		return
+	}
+
+	p.waitingMux.Lock()
+	defer p.waitingMux.Unlock()
+	delete(p.waiting, queued)
+}
+
+// queueLatency returns how many jobs are waiting for a
+// worker and for how long the oldest one has been waiting.
+func (p *Pool) queueLatency(now time.Time) (numWaiting int, latency time.Duration) {
+	p.waitingMux.Lock()
+	defer p.waitingMux.Unlock()
+
+	for queued := range p.waiting {
+		if wait := now.Sub(queued.queuedAt); wait > latency {
+			latency = wait
+		}
+	}
+	return len(p.waiting), latency
+}
+
+// Close stops accepting new jobs and makes the workers return once
+// the jobs on the queue are done, or immediately if the WithDropOnClose
+// option is set, it is safe to call Close more than once.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+
+		// Waits for any ongoing calls to Submit:
+		p.submitMux.Lock()
+		defer p.submitMux.Unlock()
+		close(p.closed)
+	})
+}
+
+// Wait waits for the workers to return after Close is called, it returns
+// the first job error if WithStopOnJobError is set and forwards panics
+// from the jobs just like Group.Wait.
+//
+// The pool can't be used again after Wait returns.
+func (p *Pool) Wait() error {
+	defer p.Close()
+	return p.g.Wait()
+}
+
+// NumWorkers returns how many workers are currently running.
+func (p *Pool) NumWorkers() int {
+	if p.cfg.maxWorkers <= 0 {
+		return p.minWorkers
+	}
+	return int(atomic.LoadInt32(&p.numWorkers))
+}
+
+func (p *Pool) worker(ctx context.Context) error {
+	return p.work(ctx, nil)
+}
+
+// poolWorker keeps the state of a worker of an autoscaling pool,
+// so the scaler can retire each of them after its own idle timeout.
+type poolWorker struct {
+	// Closed by the scaler when the worker should stop:
+	retire chan struct{}
+
+	mux       sync.Mutex
+	busy      bool
+	idleSince time.Time
+}
+
+func newPoolWorker(now time.Time) *poolWorker {
+	return &poolWorker{
+		retire:    make(chan struct{}),
+		idleSince: now,
+	}
+}
+
+func (w *poolWorker) setBusy() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.busy = true
+}
+
+func (w *poolWorker) setIdle(now time.Time) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.busy = false
+	w.idleSince = now
+}
+
+// idleFor returns for how long the worker has been idle, or zero if it is busy.
+func (w *poolWorker) idleFor(now time.Time) time.Duration {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.busy {
+		return 0
+	}
+	return now.Sub(w.idleSince)
+}
+
+// work runs jobs until the pool is closed or stopped, or until the
+// worker is retired by the scaler, w is only set if the pool is autoscaling.
+func (p *Pool) work(ctx context.Context, w *poolWorker) error {
+	var retire chan struct{}
+	if w != nil {
+		retire = w.retire
+	}
+
+	for {
+		if p.cfg.dropOnClose {
+			// Checked first so queued jobs aren't picked after Close:
+			select {
+			case <-p.closed:
+				return nil
+			default:
+			}
+		}
+
+		select {
+		case queued := <-p.queue:
+			err := p.runJob(ctx, w, queued)
+			if err != nil {
+				return err
+			}
+		case <-p.closed:
+			if p.cfg.dropOnClose {
+				return nil
+			}
+			return p.drain(ctx, w)
+		case <-retire:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *Pool) drain(ctx context.Context, w *poolWorker) error {
+	for {
+		select {
+		case queued := <-p.queue:
+			err := p.runJob(ctx, w, queued)
+			if err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *Pool) runJob(ctx context.Context, w *poolWorker, queued *queuedJob) error {
+	p.stopWaiting(queued)
+
+	if w != nil {
+		w.setBusy()
+	}
+	err := queued.job(ctx)
+	if w != nil {
+		w.setIdle(p.clock.Now())
+	}
+	if err == nil {
+		return nil
+	}
+
+	if p.cfg.jobErrorHandler != nil {
+		p.cfg.jobErrorHandler(err)
+	}
+	if p.cfg.stopOnJobError {
+		p.stopOnce.Do(func() {
+			close(p.stopped)
+		})
+		return err
+	}
+	return nil
+}
+
+// scaler starts and stops the workers of an autoscaling pool,
+// it is the only worker of the pool's group and runs the actual
+// workers with scaledWorkers.
+func (p *Pool) scaler(ctx context.Context) error {
+	workers := newScaledWorkers(ctx)
+	// The initial workers were already counted by NewPool:
+	var active []*poolWorker
+	for i := 0; i < p.minWorkers; i++ {
+		active = append(active, p.goWorker(workers))
+	}
+
+	for {
+		select {
+		case <-p.closed:
+			return workers.Wait()
+		case <-workers.ctx.Done():
+			return workers.Wait()
+		case <-p.clock.After(p.cfg.scaleInterval):
+		}
+
+		active = p.scale(workers, active)
+	}
+}
+
+// scale makes a single scaling decision and returns the workers
+// that are still active, i.e. the ones that weren't retired.
+func (p *Pool) scale(workers *scaledWorkers, active []*poolWorker) []*poolWorker {
+	now := p.clock.Now()
+	numWaiting, latency := p.queueLatency(now)
+
+	if numWaiting > 0 {
+		if latency < p.cfg.scaleUpLatency {
+			return active
+		}
+		for i := 0; i < numWaiting && len(active) < p.cfg.maxWorkers; i++ {
+			active = append(active, p.startWorker(workers))
+		}
+		return active
+	}
+
+	numActive := len(active)
+	remaining := active[:0]
+	for _, w := range active {
+		if numActive > p.minWorkers && w.idleFor(now) >= p.cfg.idleTimeout {
+			// The worker might have just picked a new job,
+			// in which case it only returns after running it:
+			close(w.retire)
+			numActive--
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	return remaining
+}
+
+func (p *Pool) startWorker(workers *scaledWorkers) *poolWorker {
+	atomic.AddInt32(&p.numWorkers, 1)
+	return p.goWorker(workers)
+}
+
+func (p *Pool) goWorker(workers *scaledWorkers) *poolWorker {
+	w := newPoolWorker(p.clock.Now())
+	workers.Go(func(ctx context.Context) error {
+		defer atomic.AddInt32(&p.numWorkers, -1)
+		return p.work(ctx, w)
+	})
+	return w
+}
+
+// scaledWorkers runs the workers of an autoscaling pool. It works like a
+// Group, canceling the other workers on the first error and forwarding
+// panics, but it keeps no state about the workers that already returned,
+// so it doesn't grow while a long-lived pool scales up and down.
+type scaledWorkers struct {
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+
+	mux      sync.Mutex
+	err      error
+	panicErr *PanicError
+}
+
+func newScaledWorkers(ctx context.Context) *scaledWorkers {
+	ctx, cancel := context.WithCancel(ctx)
+	return &scaledWorkers{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (s *scaledWorkers) Go(fn Worker) {
+	var tracked *goroutines.Goroutine
+	if goroutines.Tracking() {
+		tracked = goroutines.Track("pool worker")
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer tracked.Started()()
+		defer s.wg.Done()
+
+		panicErr, err := runWorker(s.ctx, fn)
+		if panicErr == nil && err == nil {
+			return
+		}
+
+		s.cancel()
+		if err == ErrStartGracefulShutdown {
+			return
+		}
+		safe.Do(&s.mux, func() {
+			// Only the first panic or error is kept, the
+			// others are likely caused by the shutdown:
+			if s.panicErr == nil && s.err == nil {
+				s.panicErr, s.err = panicErr, err
+			}
+		})
+	}()
+}
+
+// Wait waits for all the workers to return and returns the first
+// error, or forwards the first panic to the pool's group just like
+// it happens with the panics inside of a SubGroup.
+func (s *scaledWorkers) Wait() error {
+	s.wg.Wait()
+	s.cancel()
+
+	if s.panicErr != nil {
+		panic(subGroupPanic{s.panicErr})
+	}
+	return s.err
+}
@@ -0,0 +1,506 @@
+package threads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+	"github.com/blackpointcyber/threads/safe"
+)
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should run all submitted jobs with at most n workers", func(t *testing.T) {
+		var counter concurrencyCounter
+		var numCalls int32
+
+		p := NewPool(ctx, 2)
+		for i := 0; i < 10; i++ {
+			err := p.Submit(ctx, func(ctx context.Context) error {
+				defer counter.enter()()
+				time.Sleep(100 * time.Microsecond)
+				atomic.AddInt32(&numCalls, 1)
+				return nil
+			})
+			tt.AssertNoErr(t, err)
+		}
+		p.Close()
+
+		err := p.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(10))
+		tt.AssertEqual(t, counter.max() <= 2, true)
+	})
+
+	t.Run("should reject jobs when the queue is full", func(t *testing.T) {
+		releaseCh := make(chan struct{})
+		startedCh := make(chan struct{})
+
+		p := NewPool(ctx, 1, WithQueueSize(1), WithRejectWhenFull())
+
+		// Occupies the only worker:
+		err := p.Submit(ctx, func(ctx context.Context) error {
+			close(startedCh)
+			<-releaseCh
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+		<-startedCh
+
+		// Fills the queue:
+		err = p.Submit(ctx, func(ctx context.Context) error { return nil })
+		tt.AssertNoErr(t, err)
+
+		err = p.Submit(ctx, func(ctx context.Context) error { return nil })
+		tt.AssertEqual(t, err, ErrQueueFull)
+
+		close(releaseCh)
+		p.Close()
+		tt.AssertNoErr(t, p.Wait())
+	})
+
+	t.Run("should block when the queue is full until the context is canceled", func(t *testing.T) {
+		releaseCh := make(chan struct{})
+
+		p := NewPool(ctx, 1, WithQueueSize(0))
+		err := p.Submit(ctx, func(ctx context.Context) error {
+			<-releaseCh
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+
+		submitCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+		err = p.Submit(submitCtx, func(ctx context.Context) error { return nil })
+		tt.AssertEqual(t, err, context.DeadlineExceeded)
+
+		close(releaseCh)
+		p.Close()
+		tt.AssertNoErr(t, p.Wait())
+	})
+
+	t.Run("should run the queued jobs before returning after Close", func(t *testing.T) {
+		var numCalls int32
+		releaseCh := make(chan struct{})
+
+		p := NewPool(ctx, 1, WithQueueSize(5))
+		for i := 0; i < 5; i++ {
+			err := p.Submit(ctx, func(ctx context.Context) error {
+				<-releaseCh
+				atomic.AddInt32(&numCalls, 1)
+				return nil
+			})
+			tt.AssertNoErr(t, err)
+		}
+
+		p.Close()
+		err := p.Submit(ctx, func(ctx context.Context) error { return nil })
+		tt.AssertEqual(t, err, ErrPoolClosed)
+
+		close(releaseCh)
+		tt.AssertNoErr(t, p.Wait())
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(5))
+	})
+
+	t.Run("should drop the queued jobs on Close if configured to", func(t *testing.T) {
+		var numCalls int32
+		startedCh := make(chan struct{})
+		releaseCh := make(chan struct{})
+
+		p := NewPool(ctx, 1, WithQueueSize(5), WithDropOnClose())
+		err := p.Submit(ctx, func(ctx context.Context) error {
+			close(startedCh)
+			<-releaseCh
+			atomic.AddInt32(&numCalls, 1)
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+		<-startedCh
+
+		for i := 0; i < 4; i++ {
+			err := p.Submit(ctx, func(ctx context.Context) error {
+				atomic.AddInt32(&numCalls, 1)
+				return nil
+			})
+			tt.AssertNoErr(t, err)
+		}
+
+		p.Close()
+		close(releaseCh)
+		tt.AssertNoErr(t, p.Wait())
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(1))
+	})
+
+	t.Run("should report job errors without stopping the pool", func(t *testing.T) {
+		var mux sync.Mutex
+		var errs []string
+
+		p := NewPool(ctx, 1, WithJobErrorHandler(func(err error) {
+			safe.Do(&mux, func() {
+				errs = append(errs, err.Error())
+			})
+		}))
+		for i := 0; i < 3; i++ {
+			i := i
+			err := p.Submit(ctx, func(ctx context.Context) error {
+				return fmt.Errorf("fakeErr%d", i)
+			})
+			tt.AssertNoErr(t, err)
+		}
+		p.Close()
+
+		tt.AssertNoErr(t, p.Wait())
+		tt.AssertEqual(t, errs, []string{"fakeErr0", "fakeErr1", "fakeErr2"})
+	})
+
+	t.Run("should stop on the first job error if configured to", func(t *testing.T) {
+		p := NewPool(ctx, 2, WithStopOnJobError())
+		err := p.Submit(ctx, func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+		tt.AssertNoErr(t, err)
+
+		err = p.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+
+		err = p.Submit(ctx, func(ctx context.Context) error { return nil })
+		tt.AssertEqual(t, err, ErrPoolClosed)
+	})
+
+	t.Run("should reject the jobs submitted after a job error stops the pool", func(t *testing.T) {
+		for _, opts := range [][]PoolOption{
+			{WithStopOnJobError()},
+			{WithStopOnJobError(), WithRejectWhenFull()},
+			{WithStopOnJobError(), WithAutoscaling(2)},
+		} {
+			p := NewPool(ctx, 1, append(opts, WithQueueSize(10))...)
+			err := p.Submit(ctx, func(ctx context.Context) error {
+				return fmt.Errorf("fakeErrMsg")
+			})
+			tt.AssertNoErr(t, err)
+			<-p.stopped
+
+			// The queue has room, but the jobs would never run:
+			var numCalls int32
+			for i := 0; i < 10; i++ {
+				err = p.Submit(ctx, func(ctx context.Context) error {
+					atomic.AddInt32(&numCalls, 1)
+					return nil
+				})
+				tt.AssertEqual(t, err, ErrPoolClosed)
+			}
+
+			tt.AssertErrContains(t, p.Wait(), "fakeErrMsg")
+			tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(0))
+		}
+	})
+
+	t.Run("should reject the jobs submitted after the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		p := NewPool(ctx, 1, WithQueueSize(10))
+		cancel()
+
+		err := p.Submit(context.Background(), func(ctx context.Context) error { return nil })
+		tt.AssertEqual(t, err, ErrPoolClosed)
+		tt.AssertNoErr(t, p.Wait())
+	})
+
+	t.Run("should forward panics from the jobs", func(t *testing.T) {
+		p := NewPool(ctx, 1)
+		err := p.Submit(ctx, panickingWorker)
+		tt.AssertNoErr(t, err)
+
+		panicPayload, _ := tt.PanicHandler(func() {
+			p.Wait()
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakePanicPayload")
+	})
+}
+
+func TestPoolAutoscaling(t *testing.T) {
+	// mockScaleTicks controls the ticks of the scaler, tick() advances
+	// the clock by the scale interval and only returns after the scaler
+	// is waiting for the next tick again.
+	mockScaleTicks := func(ctx context.Context) (context.Context, func()) {
+		var mux sync.Mutex
+		now := time.Now()
+		getNow := func() time.Time {
+			mux.Lock()
+			defer mux.Unlock()
+			return now
+		}
+
+		tickCh := make(chan struct{})
+		waitingCh := make(chan struct{})
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			for {
+				d := <-waitCh
+				waitingCh <- struct{}{}
+				<-tickCh
+				safe.Do(&mux, func() {
+					now = now.Add(d)
+				})
+				triggerCh <- getNow()
+			}
+		}))
+		ctx = ContextWithClock(ctx, mockNowClock{
+			Clock: ClockFromContext(ctx),
+			now:   getNow,
+		})
+
+		var waitingOnce sync.Once
+		return ctx, func() {
+			waitingOnce.Do(func() { <-waitingCh })
+			tickCh <- struct{}{}
+			<-waitingCh
+		}
+	}
+
+	t.Run("should start workers while there are jobs waiting and retire them when idle", func(t *testing.T) {
+		ctx, tick := mockScaleTicks(context.Background())
+
+		p := NewPool(ctx, 1,
+			WithAutoscaling(3),
+			WithScaleInterval(time.Second),
+			WithIdleTimeout(2*time.Second),
+		)
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+
+		var doneWg sync.WaitGroup
+		startedCh := make(chan struct{}, 4)
+		releaseCh := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			doneWg.Add(1)
+			err := p.Submit(ctx, func(ctx context.Context) error {
+				defer doneWg.Done()
+				startedCh <- struct{}{}
+				<-releaseCh
+				return nil
+			})
+			tt.AssertNoErr(t, err)
+		}
+
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 3)
+
+		// The 4th job can't start until one of the others finish:
+		for i := 0; i < 3; i++ {
+			<-startedCh
+		}
+		close(releaseCh)
+		doneWg.Wait()
+
+		// The workers might take a moment to become idle:
+		for i := 0; i < 100 && p.NumWorkers() > 1; i++ {
+			tick()
+			time.Sleep(time.Millisecond)
+		}
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+
+		p.Close()
+		tt.AssertNoErr(t, p.Wait())
+		tt.AssertEqual(t, p.NumWorkers(), 0)
+	})
+
+	t.Run("should not retire workers before the idle timeout", func(t *testing.T) {
+		ctx, tick := mockScaleTicks(context.Background())
+
+		p := NewPool(ctx, 0,
+			WithAutoscaling(1),
+			WithScaleInterval(time.Second),
+			WithIdleTimeout(3*time.Second),
+		)
+		tt.AssertEqual(t, p.NumWorkers(), 0)
+
+		doneCh := make(chan struct{})
+		err := p.Submit(ctx, func(ctx context.Context) error {
+			close(doneCh)
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+		<-doneCh
+
+		tick()
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+
+		p.Close()
+		tt.AssertNoErr(t, p.Wait())
+	})
+
+	t.Run("should only start workers once the jobs wait longer than the scale up latency", func(t *testing.T) {
+		ctx, tick := mockScaleTicks(context.Background())
+
+		p := NewPool(ctx, 1,
+			WithAutoscaling(2),
+			WithScaleInterval(time.Second),
+			WithScaleUpLatency(2*time.Second),
+		)
+
+		startedCh := make(chan struct{})
+		releaseCh := make(chan struct{})
+		err := p.Submit(ctx, func(ctx context.Context) error {
+			close(startedCh)
+			<-releaseCh
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+		<-startedCh
+
+		doneCh := make(chan struct{})
+		err = p.Submit(ctx, func(ctx context.Context) error {
+			close(doneCh)
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+
+		// The second job has only waited for 1s:
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 2)
+		tt.AssertDone(t, time.Second, doneCh)
+
+		close(releaseCh)
+		p.Close()
+		tt.AssertNoErr(t, p.Wait())
+	})
+
+	t.Run("should retire each worker after its own idle timeout", func(t *testing.T) {
+		ctx, tick := mockScaleTicks(context.Background())
+
+		p := NewPool(ctx, 0,
+			WithAutoscaling(2),
+			WithScaleInterval(time.Second),
+			WithIdleTimeout(3*time.Second),
+		)
+
+		firstStartedCh := make(chan struct{})
+		firstReleaseCh := make(chan struct{})
+		err := p.Submit(ctx, func(ctx context.Context) error {
+			close(firstStartedCh)
+			<-firstReleaseCh
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+		<-firstStartedCh
+
+		secondDoneCh := make(chan struct{})
+		err = p.Submit(ctx, func(ctx context.Context) error {
+			close(secondDoneCh)
+			return nil
+		})
+		tt.AssertNoErr(t, err)
+
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 2)
+		<-secondDoneCh
+
+		// The second worker is idle but the first one is still busy:
+		tick()
+		tick()
+		close(firstReleaseCh)
+
+		// The workers might take a moment to return:
+		tick()
+		for i := 0; i < 100 && p.NumWorkers() > 1; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+
+		// The first worker only became idle 1s ago:
+		tick()
+		tt.AssertEqual(t, p.NumWorkers(), 1)
+
+		p.Close()
+		tt.AssertNoErr(t, p.Wait())
+	})
+
+	t.Run("should start workers for blocked calls to Submit", func(t *testing.T) {
+		ctx, tick := mockScaleTicks(context.Background())
+
+		p := NewPool(ctx, 0, WithAutoscaling(2), WithQueueSize(0))
+
+		var numCalls int32
+		submitErrCh := make(chan error)
+		go func() {
+			submitErrCh <- p.Submit(ctx, func(ctx context.Context) error {
+				atomic.AddInt32(&numCalls, 1)
+				return nil
+			})
+		}()
+
+		// Wait until Submit is blocked:
+		for {
+			if numWaiting, _ := p.queueLatency(time.Now()); numWaiting > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		tick()
+		tt.AssertNoErr(t, <-submitErrCh)
+
+		p.Close()
+		tt.AssertNoErr(t, p.Wait())
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(1))
+	})
+
+	t.Run("should forward the original panic of the jobs", func(t *testing.T) {
+		p := NewPool(context.Background(), 1,
+			WithAutoscaling(2),
+			WithGroupOptions(WithPanicAsError()),
+		)
+		fakeErr := fmt.Errorf("fakeErrMsg")
+		err := p.Submit(context.Background(), func(ctx context.Context) error {
+			panic(fakeErr)
+		})
+		tt.AssertNoErr(t, err)
+
+		err = p.Wait()
+		var panicErr *PanicError
+		tt.AssertEqual(t, errors.As(err, &panicErr), true, err)
+		tt.AssertEqual(t, panicErr.Payload, error(fakeErr))
+		tt.AssertContains(t, panicErr.Stack, "pool_test.go")
+		tt.AssertEqual(t, p.NumWorkers(), 0)
+	})
+
+	t.Run("should stop on job errors if configured to", func(t *testing.T) {
+		p := NewPool(context.Background(), 1, WithAutoscaling(2), WithStopOnJobError())
+		err := p.Submit(context.Background(), func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+		tt.AssertNoErr(t, err)
+
+		err = p.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+}
+
+// mockNowClock replaces the Now method of a clock, so the time
+// measured by the pool moves together with the mocked ticks.
+type mockNowClock struct {
+	Clock
+	now func() time.Time
+}
+
+func (c mockNowClock) Now() time.Time {
+	return c.now()
+}
+
+func (c mockNowClock) Since(t time.Time) time.Duration {
+	return c.now().Sub(t)
+}
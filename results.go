@@ -0,0 +1,67 @@
+package threads
+
+import "context"
+
+// ResultWorker is a Worker that also produces a value.
+type ResultWorker[T any] func(ctx context.Context) (T, error)
+
+// ResultGroup works like a Group but for workers that produce
+// values, which are returned by Wait in the same order the
+// workers were passed to Go.
+type ResultGroup[T any] struct {
+	g Group
+
+	// One slot per worker, each written only by its own worker:
+	slots []*T
+}
+
+func NewResultGroup[T any](ctx context.Context, opts ...GroupOption) ResultGroup[T] {
+	return ResultGroup[T]{
+		g: NewGroup(ctx, opts...),
+	}
+}
+
+// ForkAndCollect is the typed counterpart of ForkAndWait, it runs all
+// the workers in parallel and returns their results in the same order.
+func ForkAndCollect[T any](ctx context.Context, fns ...ResultWorker[T]) ([]T, error) {
+	g := NewResultGroup[T](ctx)
+	for _, fn := range fns {
+		g.Go(fn)
+	}
+
+	return g.Wait()
+}
+
+func (r *ResultGroup[T]) Go(fn ResultWorker[T]) {
+	slot := new(T)
+	r.slots = append(r.slots, slot)
+
+	r.g.Go(func(ctx context.Context) error {
+		v, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+
+		*slot = v
+		return nil
+	})
+}
+
+// Wait works like Group.Wait but also returns the results of the
+// workers in the order they were started, if any of the workers
+// fails the results are discarded and only the error is returned.
+func (r *ResultGroup[T]) Wait() ([]T, error) {
+	slots := r.slots
+	r.slots = nil
+
+	err := r.g.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(slots))
+	for _, slot := range slots {
+		results = append(results, *slot)
+	}
+	return results, nil
+}
@@ -0,0 +1,114 @@
+package threads
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestResultGroup(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return the results in the order the workers were started", func(t *testing.T) {
+		g := NewResultGroup[string](ctx)
+		g.Go(func(ctx context.Context) (string, error) {
+			time.Sleep(time.Millisecond)
+			return "first", nil
+		})
+		g.Go(func(ctx context.Context) (string, error) {
+			return "second", nil
+		})
+
+		results, err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, results, []string{"first", "second"})
+	})
+
+	t.Run("should cancel the other workers and return the first error", func(t *testing.T) {
+		g := NewResultGroup[int](ctx)
+		g.Go(func(ctx context.Context) (int, error) {
+			return 0, fmt.Errorf("fakeErrMsg")
+		})
+
+		var isCtxCanceled bool
+		g.Go(func(ctx context.Context) (int, error) {
+			<-ctx.Done()
+			isCtxCanceled = true
+			return 42, nil
+		})
+
+		results, err := g.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertEqual(t, results, []int(nil))
+		tt.AssertEqual(t, isCtxCanceled, true)
+	})
+
+	t.Run("should forward panics to the waiting Goroutine", func(t *testing.T) {
+		g := NewResultGroup[int](ctx)
+		g.Go(func(ctx context.Context) (int, error) {
+			panic("fakePanicPayload")
+		})
+
+		panicPayload, _ := tt.PanicHandler(func() {
+			g.Wait()
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakePanicPayload")
+	})
+
+	t.Run("should accept the same options as NewGroup", func(t *testing.T) {
+		g := NewResultGroup[int](ctx, WithPanicAsError())
+		g.Go(func(ctx context.Context) (int, error) {
+			panic("fakePanicPayload")
+		})
+
+		_, err := g.Wait()
+		tt.AssertErrContains(t, err, "panicked", "fakePanicPayload")
+	})
+
+	t.Run("should only return the results of the workers started after the last Wait", func(t *testing.T) {
+		g := NewResultGroup[int](ctx)
+		g.Go(func(ctx context.Context) (int, error) {
+			return 1, nil
+		})
+		results, err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, results, []int{1})
+
+		g.Go(func(ctx context.Context) (int, error) {
+			return 2, nil
+		})
+		results, err = g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, results, []int{2})
+	})
+}
+
+func TestForkAndCollect(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should run the workers in parallel and collect the results", func(t *testing.T) {
+		results, err := ForkAndCollect(ctx,
+			func(ctx context.Context) (int, error) {
+				time.Sleep(time.Millisecond)
+				return 1, nil
+			},
+			func(ctx context.Context) (int, error) {
+				return 2, nil
+			},
+		)
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, results, []int{1, 2})
+	})
+
+	t.Run("should report errors correctly", func(t *testing.T) {
+		_, err := ForkAndCollect(ctx,
+			func(ctx context.Context) (int, error) {
+				return 0, fmt.Errorf("fakeErrMsg")
+			},
+		)
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+}
@@ -0,0 +1,183 @@
+package threads
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blackpointcyber/threads/internal/goroutines"
+)
+
+var ErrShutdownTimeout = fmt.Errorf("timeout waiting for workers to shutdown")
+
+// WithShutdownTimeout limits how long Group.Wait waits for the workers
+// to return after the context of the group is canceled.
+//
+// If the timeout expires Group.Wait returns a *ShutdownTimeoutError
+// listing the workers that are still running, these workers are not
+// stopped in any way, so they might keep running in the background.
+func WithShutdownTimeout(timeout time.Duration) GroupOption {
+	return func(g *Group) {
+		g.shutdownTimeout = timeout
+	}
+}
+
+// WithStuckWorkerStacks makes the *ShutdownTimeoutError
+// include the stacktrace of each of the stuck workers.
+func WithStuckWorkerStacks() GroupOption {
+	return func(g *Group) {
+		g.stuckWorkerStacks = true
+	}
+}
+
+// ShutdownTimeoutError is returned by Group.Wait when the timeout
+// set with WithShutdownTimeout expires before all workers return.
+//
+// It matches ErrShutdownTimeout when using errors.Is and
+// unwraps to the error that caused the shutdown, if any.
+type ShutdownTimeoutError struct {
+	Timeout      time.Duration
+	StuckWorkers []StuckWorker
+
+	// The first error returned by the workers, if any:
+	Cause error
+}
+
+// StuckWorker describes a worker that didn't return in time.
+type StuckWorker struct {
+	WorkerID   int
+	WorkerName string
+
+	// Only available if the WithStuckWorkerStacks option is used:
+	Stack string
+}
+
+func (s *ShutdownTimeoutError) Error() string {
+	workers := make([]string, 0, len(s.StuckWorkers))
+	for _, w := range s.StuckWorkers {
+		if w.WorkerName != "" {
+			workers = append(workers, w.WorkerName)
+		} else {
+			workers = append(workers, strconv.Itoa(w.WorkerID))
+		}
+	}
+
+	msg := fmt.Sprintf(
+		"%s: %d workers still running after %v: %s",
+		ErrShutdownTimeout, len(s.StuckWorkers), s.Timeout, strings.Join(workers, ", "),
+	)
+	if s.Cause != nil {
+		msg += fmt.Sprintf("; shutdown caused by: %s", s.Cause)
+	}
+	return msg
+}
+
+func (s *ShutdownTimeoutError) Is(target error) bool {
+	return target == ErrShutdownTimeout
+}
+
+func (s *ShutdownTimeoutError) Unwrap() error {
+	return s.Cause
+}
+
+func (g *Group) shutdownTimeoutErr() error {
+	var cause error
+	if errs := g.errs.list(); len(errs) > 0 {
+		cause = errs[0]
+	}
+
+	running := g.sup.runningWorkers()
+
+	var stacks map[int64]string
+	if g.stuckWorkerStacks {
+		ids := map[int64]bool{}
+		for _, w := range running {
+			ids[w.goroutineID] = true
+		}
+		stacks = goroutines.Stacks(ids)
+	}
+
+	stuckWorkers := make([]StuckWorker, 0, len(running))
+	for _, w := range running {
+		stuckWorkers = append(stuckWorkers, StuckWorker{
+			WorkerID:   w.id,
+			WorkerName: w.name,
+			Stack:      stacks[w.goroutineID],
+		})
+	}
+
+	return &ShutdownTimeoutError{
+		Timeout:      g.shutdownTimeout,
+		StuckWorkers: stuckWorkers,
+		Cause:        cause,
+	}
+}
+
+// Context returns the context passed to the workers of the group,
+// it is canceled when the group starts shutting down, so it
+// can be used for tying other operations to the group lifetime.
+//
+// Note that the group uses a new context each time it restarts.
+func (g *Group) Context() context.Context {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	return g.ctx
+}
+
+// Cancel cancels the context of the workers and prevents the group
+// from restarting, it is safe to call it while Wait is running.
+//
+// If cause is not nil it will be returned by Wait instead of the
+// errors returned by the workers.
+func (g *Group) Cancel(cause error) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if !g.cancelation.canceled {
+		g.cancelation.canceled = true
+		g.cancelation.cause = cause
+	}
+	g.cancelBase()
+}
+
+// Shutdown cancels the group and waits until all of its workers return
+// or until ctx is canceled, in which case it returns ctx.Err().
+//
+// The errors returned by the workers are still reported by Wait.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.Cancel(nil)
+
+	g.mux.Lock()
+	eg := g.g
+	g.mux.Unlock()
+
+	doneCh := make(chan struct{})
+	tracked := goroutines.Track("Group.Shutdown")
+	go func() {
+		defer tracked.Started()()
+		eg.Wait()
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type cancelation struct {
+	canceled bool
+	cause    error
+}
+
+func (g *Group) cancelState() (canceled bool, cause error) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	return g.cancelation.canceled, g.cancelation.cause
+}
@@ -0,0 +1,222 @@
+package threads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestWithShutdownTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return an error listing the stuck workers", func(t *testing.T) {
+		stuckWorkerStarted := make(chan struct{})
+
+		var timeAfterArgs []time.Duration
+		ctx := ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			<-stuckWorkerStarted
+			triggerCh <- time.Now()
+		}))
+
+		releaseCh := make(chan struct{})
+		defer close(releaseCh)
+
+		g := NewGroup(ctx, WithShutdownTimeout(time.Second), WithStuckWorkerStacks())
+		g.GoNamed("stuckWorker", func(ctx context.Context) error {
+			close(stuckWorkerStarted)
+			return ignoringCtxWorker(releaseCh)
+		})
+		g.GoNamed("failingWorker", func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "1 workers still running after 1s: stuckWorker", "fakeErrMsg")
+		tt.AssertEqual(t, errors.Is(err, ErrShutdownTimeout), true)
+		tt.AssertEqual(t, timeAfterArgs, []time.Duration{time.Second})
+
+		var timeoutErr *ShutdownTimeoutError
+		tt.AssertEqual(t, errors.As(err, &timeoutErr), true)
+		tt.AssertEqual(t, len(timeoutErr.StuckWorkers), 1)
+		tt.AssertEqual(t, timeoutErr.StuckWorkers[0].WorkerID, 0)
+		tt.AssertEqual(t, timeoutErr.StuckWorkers[0].WorkerName, "stuckWorker")
+		tt.AssertContains(t, timeoutErr.StuckWorkers[0].Stack, "ignoringCtxWorker")
+	})
+
+	t.Run("should not start the timeout before the context is canceled", func(t *testing.T) {
+		ctx := ContextWithTimeMock(ctx, func(d time.Duration) <-chan time.Time {
+			t.Errorf("time.After should not have been called")
+			return nil
+		})
+
+		g := NewGroup(ctx, WithShutdownTimeout(time.Second))
+		g.Go(func(ctx context.Context) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+	})
+}
+
+func ignoringCtxWorker(releaseCh chan struct{}) error {
+	<-releaseCh
+	return nil
+}
+
+func TestGroupCancel(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should cancel the workers and return the cause from Wait", func(t *testing.T) {
+		fakeCause := fmt.Errorf("fakeCause")
+
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		g.Cancel(fakeCause)
+
+		err := g.Wait()
+		tt.AssertEqual(t, err, fakeCause)
+	})
+
+	t.Run("should return the errors of the workers if the cause is nil", func(t *testing.T) {
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return fmt.Errorf("fakeErrMsg")
+		})
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+
+		g.Cancel(nil)
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+
+	t.Run("should prevent the group from restarting", func(t *testing.T) {
+		var numCalls int
+
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			numCalls++
+			<-ctx.Done()
+			return ErrRestartGroup
+		})
+
+		g.Cancel(nil)
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 1)
+	})
+
+	t.Run("should allow the group to be reused after Wait returns", func(t *testing.T) {
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+		g.Cancel(fmt.Errorf("fakeCause"))
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "fakeCause")
+
+		var ctxErr error
+		g.Go(func(ctx context.Context) error {
+			ctxErr = ctx.Err()
+			return nil
+		})
+		err = g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertNoErr(t, ctxErr)
+	})
+}
+
+func TestGroupShutdown(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should cancel the workers and wait for them to return", func(t *testing.T) {
+		g := NewGroup(ctx)
+
+		var returned bool
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Millisecond)
+			returned = true
+			return fmt.Errorf("fakeErrMsg")
+		})
+
+		err := g.Shutdown(ctx)
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, returned, true)
+
+		err = g.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+
+	t.Run("should stop waiting when the input context is canceled", func(t *testing.T) {
+		g := NewGroup(ctx)
+
+		releaseCh := make(chan struct{})
+		g.Go(func(ctx context.Context) error {
+			return ignoringCtxWorker(releaseCh)
+		})
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+
+		err := g.Shutdown(shutdownCtx)
+		tt.AssertEqual(t, errors.Is(err, context.DeadlineExceeded), true)
+
+		close(releaseCh)
+		err = g.Wait()
+		tt.AssertNoErr(t, err)
+	})
+
+	t.Run("should work while another Goroutine is waiting", func(t *testing.T) {
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+
+		waitErrCh := make(chan error, 1)
+		go func() {
+			waitErrCh <- g.Wait()
+		}()
+
+		err := g.Shutdown(ctx)
+		tt.AssertNoErr(t, err)
+		tt.AssertNoErr(t, <-waitErrCh)
+	})
+}
+
+func TestGroupContext(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return the context passed to the workers", func(t *testing.T) {
+		g := NewGroup(ctx)
+
+		groupCtx := g.Context()
+		tt.AssertNoErr(t, groupCtx.Err())
+
+		g.Go(func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+
+		tt.AssertErrContains(t, groupCtx.Err(), "context canceled")
+	})
+}
@@ -0,0 +1,376 @@
+package threads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/blackpointcyber/threads/safe"
+)
+
+// RestartPolicy decides if a supervised worker should be
+// restarted when it returns, see Group.GoSupervised.
+type RestartPolicy int
+
+const (
+	// Temporary workers are never restarted, this is the
+	// policy used for the workers started with Group.Go.
+	Temporary RestartPolicy = iota
+
+	// Transient workers are restarted only if they
+	// return an error or panic.
+	Transient
+
+	// Permanent workers are always restarted, even
+	// if they return nil.
+	Permanent
+)
+
+// RestartStrategy decides which workers of a Group are restarted
+// together when a supervised worker needs to be restarted.
+type RestartStrategy int
+
+const (
+	// OneForOne restarts only the worker that returned,
+	// this is the default strategy.
+	OneForOne RestartStrategy = iota
+
+	// OneForAll cancels every other running worker of the
+	// group and then restarts all of them together.
+	OneForAll
+
+	// RestForOne cancels and restarts the worker that returned
+	// and all the workers that were started after it.
+	RestForOne
+)
+
+// WithRestartStrategy sets the strategy used for restarting
+// the workers started with Group.GoSupervised.
+func WithRestartStrategy(strategy RestartStrategy) GroupOption {
+	return func(g *Group) {
+		g.restartStrategy = strategy
+	}
+}
+
+// GoSupervised works like GoNamed but also sets a RestartPolicy for
+// the worker, so instead of canceling the group when the worker returns
+// it might be restarted according to the policy and to the RestartStrategy
+// of the group.
+//
+// The name is optional and can be left empty.
+func (g *Group) GoSupervised(name string, policy RestartPolicy, fn Worker) {
+	g.goSpec(workerSpec{name: name, policy: policy, fn: fn})
+}
+
+type exitDecision int
+
+const (
+	// The worker should return normally as if it was not supervised:
+	exitNormally exitDecision = iota
+
+	// The worker should run again:
+	restartWorker
+
+	// The worker was canceled by the supervisor and should
+	// return without reporting its error:
+	stopWorker
+)
+
+type supervisor struct {
+	mux      sync.Mutex
+	strategy RestartStrategy
+	limiter  *restartLimiter
+	workers  []supervisedWorker
+}
+
+type supervisedWorker struct {
+	name        string
+	goroutineID int64
+	running     bool
+	cancel      func()
+
+	// Set when a sibling requested this worker to restart:
+	round *restartRound
+}
+
+// restartRound makes sure all the workers restarted together
+// only start running again after all of them have returned.
+type restartRound struct {
+	wg    sync.WaitGroup
+	delay time.Duration
+}
+
+func newSupervisor(strategy RestartStrategy, limiter *restartLimiter) *supervisor {
+	return &supervisor{
+		strategy: strategy,
+		limiter:  limiter,
+	}
+}
+
+// started registers a new execution of a worker and returns
+// the context the supervisor uses for canceling it.
+func (s *supervisor) started(ctx context.Context, workerID int, name string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	safe.Do(&s.mux, func() {
+		for len(s.workers) <= workerID {
+			s.workers = append(s.workers, supervisedWorker{})
+		}
+		worker := &s.workers[workerID]
+		worker.name = name
+		worker.running = true
+		worker.cancel = cancel
+	})
+
+	return ctx, cancel
+}
+
+func (s *supervisor) setGoroutineID(workerID int, goroutineID int64) {
+	safe.Do(&s.mux, func() {
+		s.workers[workerID].goroutineID = goroutineID
+	})
+}
+
+type runningWorker struct {
+	id          int
+	name        string
+	goroutineID int64
+}
+
+func (s *supervisor) runningWorkers() []runningWorker {
+	var workers []runningWorker
+	safe.Do(&s.mux, func() {
+		for id, w := range s.workers {
+			if w.running {
+				workers = append(workers, runningWorker{
+					id:          id,
+					name:        w.name,
+					goroutineID: w.goroutineID,
+				})
+			}
+		}
+	})
+	return workers
+}
+
+// exited decides what to do after a worker returns, if the decision
+// is to restart it also returns how long to wait before restarting,
+// and if the restart intensity was exceeded it returns an error that
+// should be reported instead of the one returned by the worker.
+func (s *supervisor) exited(
+	groupCtx context.Context,
+	workerID int,
+	policy RestartPolicy,
+	err error,
+	panicErr *PanicError,
+) (exitDecision, time.Duration, error) {
+	s.mux.Lock()
+	worker := &s.workers[workerID]
+	worker.running = false
+
+	if round := worker.round; round != nil {
+		worker.round = nil
+		s.mux.Unlock()
+
+		round.wg.Done()
+		round.wg.Wait()
+		if policy == Temporary || groupCtx.Err() != nil {
+			return stopWorker, 0, nil
+		}
+		return restartWorker, round.delay, nil
+	}
+
+	if groupCtx.Err() != nil || !shouldRestart(policy, err, panicErr != nil) {
+		s.mux.Unlock()
+		return exitNormally, 0, nil
+	}
+
+	cause := err
+	if panicErr != nil {
+		cause = panicErr
+	}
+	delay, tooManyRestartsErr := s.limiter.allow(ClockFromContext(groupCtx).Now(), cause)
+	if tooManyRestartsErr != nil {
+		s.mux.Unlock()
+		return exitNormally, 0, tooManyRestartsErr
+	}
+
+	if s.strategy == OneForOne {
+		s.mux.Unlock()
+		return restartWorker, delay, nil
+	}
+
+	round := &restartRound{
+		delay: delay,
+	}
+	round.wg.Add(1)
+	for i := range s.workers {
+		sibling := &s.workers[i]
+		if i == workerID || !sibling.running || sibling.round != nil {
+			continue
+		}
+		if s.strategy == RestForOne && i < workerID {
+			continue
+		}
+
+		sibling.round = round
+		round.wg.Add(1)
+		sibling.cancel()
+	}
+	s.mux.Unlock()
+
+	round.wg.Done()
+	round.wg.Wait()
+	if groupCtx.Err() != nil {
+		// The error was already handled by the restart
+		// so it shouldn't be reported to the group:
+		return stopWorker, 0, nil
+	}
+	return restartWorker, delay, nil
+}
+
+func shouldRestart(policy RestartPolicy, err error, panicked bool) bool {
+	// These errors are signals for the whole group:
+	if err == ErrStartGracefulShutdown || errors.Is(err, ErrRestartGroup) {
+		return false
+	}
+
+	switch policy {
+	case Permanent:
+		return true
+	case Transient:
+		return err != nil || panicked
+	default:
+		return false
+	}
+}
+
+var ErrTooManyRestarts = fmt.Errorf("too many restarts")
+
+// WithRestartIntensity limits how many restarts can happen on
+// the group in the given time window, including restarts caused
+// by ErrRestartGroup and by supervised workers.
+//
+// If the limit is exceeded Group.Wait returns an error
+// wrapping both ErrTooManyRestarts and the last cause of the restart.
+func WithRestartIntensity(maxRestarts int, window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.restarts.maxRestarts = maxRestarts
+		g.restarts.window = window
+	}
+}
+
+// WithRestartBackoff makes the group wait before each restart,
+// starting with the initial delay and doubling it for each
+// restart in the window of WithRestartIntensity up to maxDelay,
+// if maxDelay is zero or negative the delay has no upper limit,
+// and if it is smaller than initialDelay every delay is maxDelay.
+//
+// Without WithRestartIntensity the window is unlimited, so the
+// delay only goes back to the initial one when Group.Wait returns,
+// i.e. once it reaches maxDelay it stays there.
+//
+// A random jitter of up to half the delay is also applied, so
+// restarts on several groups don't happen at the same time.
+func WithRestartBackoff(initialDelay time.Duration, maxDelay time.Duration) GroupOption {
+	return func(g *Group) {
+		g.restarts.initialBackoff = initialDelay
+		g.restarts.maxBackoff = maxDelay
+	}
+}
+
+// The backoff delay stops growing long before this number
+// of restarts, so there is no need to remember more of them:
+const maxTrackedRestarts = 64
+
+type restartLimiter struct {
+	mux sync.Mutex
+
+	maxRestarts int
+	window      time.Duration
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	restarts []time.Time
+}
+
+// allow records a new restart happening now and returns how long to wait
+// before restarting or an error if the restart intensity was exceeded.
+func (r *restartLimiter) allow(now time.Time, cause error) (delay time.Duration, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.restarts = append(r.restarts, now)
+
+	first := 0
+	if r.window > 0 {
+		for first < len(r.restarts) && now.Sub(r.restarts[first]) > r.window {
+			first++
+		}
+	}
+	limit := maxTrackedRestarts
+	if r.maxRestarts >= limit {
+		limit = r.maxRestarts + 1
+	}
+	if len(r.restarts)-first > limit {
+		first = len(r.restarts) - limit
+	}
+	r.restarts = r.restarts[first:]
+
+	if r.maxRestarts > 0 && len(r.restarts) > r.maxRestarts {
+		return 0, fmt.Errorf(
+			"%w: more than %d restarts in %v, last cause: %w",
+			ErrTooManyRestarts, r.maxRestarts, r.window, cause,
+		)
+	}
+
+	return r.backoff(len(r.restarts)), nil
+}
+
+func (r *restartLimiter) backoff(numRestarts int) time.Duration {
+	if r.initialBackoff <= 0 {
+		return 0
+	}
+
+	d := r.initialBackoff
+	for i := 1; i < numRestarts; i++ {
+		if r.maxBackoff > 0 && d >= r.maxBackoff {
+			break
+		}
+		if d > math.MaxInt64/2 {
+			// Without a maxDelay doubling it again would overflow:
+			break
+		}
+		d *= 2
+	}
+	if r.maxBackoff > 0 && d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (r *restartLimiter) reset() {
+	safe.Do(&r.mux, func() {
+		r.restarts = nil
+	})
+}
+
+// waitBackoff waits for the restart delay and returns
+// false if the context was canceled while waiting.
+func waitBackoff(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ClockFromContext(ctx).After(delay):
+		return true
+	}
+}
@@ -0,0 +1,351 @@
+package threads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestGoSupervised(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should not restart temporary workers", func(t *testing.T) {
+		var numCalls int
+
+		g := NewGroup(ctx)
+		g.GoSupervised("fakeWorker", Temporary, func(ctx context.Context) error {
+			numCalls++
+			return fmt.Errorf("fakeErrMsg")
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertEqual(t, numCalls, 1)
+	})
+
+	t.Run("should restart transient workers only on errors and panics", func(t *testing.T) {
+		var numCalls int
+
+		g := NewGroup(ctx)
+		g.GoSupervised("", Transient, func(ctx context.Context) error {
+			numCalls++
+			switch numCalls {
+			case 1:
+				return fmt.Errorf("fakeErrMsg")
+			case 2:
+				panic("fakePanicPayload")
+			default:
+				return nil
+			}
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 3)
+	})
+
+	t.Run("should restart permanent workers even if they return nil", func(t *testing.T) {
+		var numCalls int
+
+		g := NewGroup(ctx)
+		g.GoSupervised("", Permanent, func(ctx context.Context) error {
+			numCalls++
+			if numCalls == 3 {
+				return ErrStartGracefulShutdown
+			}
+			return nil
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 3)
+	})
+
+	t.Run("should stop restarting workers once the group is canceled", func(t *testing.T) {
+		var numCalls int
+
+		g := NewGroup(ctx)
+		g.GoSupervised("", Permanent, func(ctx context.Context) error {
+			numCalls++
+			<-ctx.Done()
+			return nil
+		})
+		g.Go(func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertEqual(t, numCalls, 1)
+	})
+
+	t.Run("one for one should only restart the worker that returned", func(t *testing.T) {
+		var numCallsFlaky int
+		var numCallsHealthy int32
+		var healthyCanceledEarly bool
+		healthyCanceled := make(chan struct{})
+
+		g := NewGroup(ctx, WithRestartStrategy(OneForOne))
+		g.GoSupervised("healthy", Permanent, func(ctx context.Context) error {
+			atomic.AddInt32(&numCallsHealthy, 1)
+			<-ctx.Done()
+			close(healthyCanceled)
+			return nil
+		})
+		g.GoSupervised("flaky", Transient, func(ctx context.Context) error {
+			numCallsFlaky++
+			if numCallsFlaky < 3 {
+				return fmt.Errorf("fakeErrMsg")
+			}
+			select {
+			case <-healthyCanceled:
+				healthyCanceledEarly = true
+			default:
+			}
+			return ErrStartGracefulShutdown
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCallsFlaky, 3)
+		tt.AssertEqual(t, healthyCanceledEarly, false)
+		tt.AssertEqual(t, atomic.LoadInt32(&numCallsHealthy), int32(1))
+	})
+
+	t.Run("one for all should restart every running worker", func(t *testing.T) {
+		var numCallsFlaky, numCallsPermanent, numCallsTemporary int32
+		permanentRestarted := make(chan struct{})
+
+		g := NewGroup(ctx, WithRestartStrategy(OneForAll))
+		g.GoSupervised("permanent", Permanent, func(ctx context.Context) error {
+			if atomic.AddInt32(&numCallsPermanent, 1) == 2 {
+				close(permanentRestarted)
+			}
+			<-ctx.Done()
+			return nil
+		})
+		g.Go(func(ctx context.Context) error {
+			atomic.AddInt32(&numCallsTemporary, 1)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		g.GoSupervised("flaky", Transient, func(ctx context.Context) error {
+			if atomic.AddInt32(&numCallsFlaky, 1) == 1 {
+				return fmt.Errorf("fakeErrMsg")
+			}
+			<-permanentRestarted
+			return ErrStartGracefulShutdown
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&numCallsFlaky), int32(2))
+		tt.AssertEqual(t, atomic.LoadInt32(&numCallsPermanent), int32(2))
+		tt.AssertEqual(t, atomic.LoadInt32(&numCallsTemporary), int32(1))
+	})
+
+	t.Run("rest for one should restart the workers started after the one that returned", func(t *testing.T) {
+		var numCallsFirst, numCallsFlaky, numCallsLast int32
+		lastStarted := make(chan struct{})
+		flakyRestarted := make(chan struct{})
+
+		g := NewGroup(ctx, WithRestartStrategy(RestForOne))
+		g.GoSupervised("first", Permanent, func(ctx context.Context) error {
+			atomic.AddInt32(&numCallsFirst, 1)
+			<-ctx.Done()
+			return nil
+		})
+		g.GoSupervised("flaky", Transient, func(ctx context.Context) error {
+			if atomic.AddInt32(&numCallsFlaky, 1) == 1 {
+				<-lastStarted
+				return fmt.Errorf("fakeErrMsg")
+			}
+			close(flakyRestarted)
+			<-ctx.Done()
+			return nil
+		})
+		g.GoSupervised("last", Permanent, func(ctx context.Context) error {
+			if atomic.AddInt32(&numCallsLast, 1) == 2 {
+				<-flakyRestarted
+				return ErrStartGracefulShutdown
+			}
+			close(lastStarted)
+			<-ctx.Done()
+			return nil
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&numCallsFirst), int32(1))
+		tt.AssertEqual(t, atomic.LoadInt32(&numCallsFlaky), int32(2))
+		tt.AssertEqual(t, atomic.LoadInt32(&numCallsLast), int32(2))
+	})
+}
+
+func TestRestartLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should stop restarting the group after too many restarts", func(t *testing.T) {
+		var numCalls int
+
+		g := NewGroup(ctx, WithRestartIntensity(3, time.Minute))
+		g.Go(func(ctx context.Context) error {
+			numCalls++
+			return ErrRestartGroup
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "too many restarts", "more than 3 restarts in 1m0s")
+		tt.AssertEqual(t, errors.Is(err, ErrTooManyRestarts), true)
+		tt.AssertEqual(t, errors.Is(err, ErrRestartGroup), true)
+		tt.AssertEqual(t, numCalls, 4)
+	})
+
+	t.Run("should stop restarting supervised workers after too many restarts", func(t *testing.T) {
+		var numCalls int
+		fakeErr := fmt.Errorf("fakeErrMsg")
+
+		g := NewGroup(ctx, WithRestartIntensity(2, time.Minute))
+		g.GoSupervised("flaky", Transient, func(ctx context.Context) error {
+			numCalls++
+			return fakeErr
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "worker flaky", "too many restarts", "fakeErrMsg")
+		tt.AssertEqual(t, errors.Is(err, ErrTooManyRestarts), true)
+		tt.AssertEqual(t, errors.Is(err, fakeErr), true)
+		tt.AssertEqual(t, numCalls, 3)
+	})
+
+	t.Run("should reset the restart count when Wait returns", func(t *testing.T) {
+		g := NewGroup(ctx, WithRestartIntensity(1, time.Minute))
+
+		for i := 0; i < 3; i++ {
+			restarted := false
+			g.Go(func(ctx context.Context) error {
+				if !restarted {
+					restarted = true
+					return ErrRestartGroup
+				}
+				return nil
+			})
+
+			err := g.Wait()
+			tt.AssertNoErr(t, err)
+		}
+	})
+
+	t.Run("should wait with an exponential backoff between restarts", func(t *testing.T) {
+		var timeAfterArgs []time.Duration
+		ctx := ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			for i := 0; i < 4; i++ {
+				timeAfterArgs = append(timeAfterArgs, <-waitCh)
+				triggerCh <- time.Now()
+			}
+		}))
+
+		var numCalls int
+		g := NewGroup(ctx, WithRestartBackoff(10*time.Millisecond, 40*time.Millisecond))
+		g.GoSupervised("flaky", Transient, func(ctx context.Context) error {
+			numCalls++
+			if numCalls <= 4 {
+				panic("fakePanicPayload")
+			}
+			return nil
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 5)
+
+		tt.AssertEqual(t, len(timeAfterArgs), 4)
+		for i, maxDelay := range []time.Duration{
+			10 * time.Millisecond,
+			20 * time.Millisecond,
+			40 * time.Millisecond,
+			40 * time.Millisecond,
+		} {
+			tt.AssertEqual(t, timeAfterArgs[i] >= maxDelay/2 && timeAfterArgs[i] <= maxDelay, true,
+				"expected delay %d to be between %v and %v but got %v", i, maxDelay/2, maxDelay, timeAfterArgs[i],
+			)
+		}
+	})
+
+	t.Run("should not restart the group if it is canceled during the backoff", func(t *testing.T) {
+		backoffCh := make(chan struct{})
+		ctx := ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			<-waitCh
+			// Never triggers, so the group stays on the backoff:
+			close(backoffCh)
+		}))
+
+		var numStarts int32
+		g := NewGroup(ctx, WithRestartBackoff(time.Hour, time.Hour))
+		g.Go(func(ctx context.Context) error {
+			atomic.AddInt32(&numStarts, 1)
+			return ErrRestartGroup
+		})
+
+		go func() {
+			<-backoffCh
+			g.Cancel(nil)
+		}()
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&numStarts), int32(1))
+	})
+
+	t.Run("should stop waiting for the backoff when the wait times out", func(t *testing.T) {
+		ctx := ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			<-waitCh
+		}))
+
+		var numStarts int32
+		g := NewGroup(ctx, WithRestartBackoff(time.Hour, time.Hour))
+		g.Go(func(ctx context.Context) error {
+			atomic.AddInt32(&numStarts, 1)
+			return ErrRestartGroup
+		})
+
+		err := g.WaitTimeout(10 * time.Millisecond)
+		tt.AssertEqual(t, err, context.DeadlineExceeded)
+		tt.AssertEqual(t, atomic.LoadInt32(&numStarts), int32(1))
+	})
+
+	t.Run("should limit the backoff to maxDelay even if it is smaller than the initial delay", func(t *testing.T) {
+		r := &restartLimiter{initialBackoff: time.Minute, maxBackoff: time.Second}
+		for numRestarts := 1; numRestarts <= 3; numRestarts++ {
+			d := r.backoff(numRestarts)
+			tt.AssertEqual(t, d >= time.Second/2 && d <= time.Second, true,
+				"expected delay %d to be between %v and %v but got %v", numRestarts, time.Second/2, time.Second, d,
+			)
+		}
+	})
+
+	t.Run("should not limit the backoff if maxDelay is not positive", func(t *testing.T) {
+		for _, maxDelay := range []time.Duration{0, -time.Second} {
+			r := &restartLimiter{initialBackoff: time.Second, maxBackoff: maxDelay}
+			for i, expected := range []time.Duration{
+				time.Second,
+				2 * time.Second,
+				4 * time.Second,
+				8 * time.Second,
+			} {
+				d := r.backoff(i + 1)
+				tt.AssertEqual(t, d >= expected/2 && d <= expected, true,
+					"expected delay %d to be between %v and %v but got %v", i, expected/2, expected, d,
+				)
+			}
+
+			// Shouldn't overflow even after the maximum number of restarts:
+			tt.AssertEqual(t, r.backoff(maxTrackedRestarts) > 0, true)
+		}
+	})
+}
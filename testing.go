@@ -7,16 +7,27 @@ import (
 
 type timeAfter func(d time.Duration) <-chan time.Time
 
-type ctxTimeMockKey struct{}
-
+// ContextWithTimeMock replaces only the After method of the
+// clock on the context, the other methods keep working normally.
+//
+// Deprecated: use ContextWithClock instead, e.g. with the
+// fake clock from the threadstest package.
 func ContextWithTimeMock(ctx context.Context, t timeAfter) context.Context {
-	return context.WithValue(ctx, ctxTimeMockKey{}, t)
+	return ContextWithClock(ctx, timeAfterClock{
+		Clock: ClockFromContext(ctx),
+		after: t,
+	})
+}
+
+type timeAfterClock struct {
+	Clock
+	after timeAfter
+}
+
+func (c timeAfterClock) After(d time.Duration) <-chan time.Time {
+	return c.after(d)
 }
 
-func getTimeAfter(ctx context.Context) timeAfter {
-	t, _ := ctx.Value(ctxTimeMockKey{}).(timeAfter)
-	if t != nil {
-		return t
-	}
-	return time.After
+func (c timeAfterClock) Sleep(d time.Duration) {
+	<-c.after(d)
 }
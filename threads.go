@@ -5,14 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"runtime/debug"
-
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/blackpointcyber/threads/internal/goroutines"
+	"github.com/blackpointcyber/threads/safe"
 	"golang.org/x/sync/errgroup"
 )
 
 var ErrStartGracefulShutdown = fmt.Errorf("signal to stop the execution gracefully")
 var ErrRestartGroup = fmt.Errorf("signal to restart the current threads.Group")
+var ErrWorkersNotStarted = fmt.Errorf("some workers were not started because the group was canceled")
 
 type Worker func(ctx context.Context) error
 
@@ -25,109 +30,560 @@ func ForkAndWait(ctx context.Context, fns ...Worker) error {
 	return g.Wait()
 }
 
+// ForkAndWaitN works like ForkAndWait but runs
+// at most n of the workers at the same time, the
+// workers that were still waiting when the context
+// was canceled are not started, see WithLimit.
+func ForkAndWaitN(ctx context.Context, n int, fns ...Worker) error {
+	g := NewGroup(ctx, WithLimit(n))
+	for _, fn := range fns {
+		g.Go(fn)
+	}
+
+	return g.Wait()
+}
+
 type Group struct {
 	g         *errgroup.Group
 	ctx       context.Context
 	parentCtx context.Context
 	cancel    func()
 
+	// The base context is only canceled by Cancel and Shutdown,
+	// and the cancelation cause is returned by Wait:
+	baseCtx     context.Context
+	cancelBase  func()
+	cancelation *cancelation
+
+	// Protects the fields above that might be accessed
+	// by Cancel, Shutdown and Context while Wait is running:
+	mux *sync.Mutex
+
 	// A list of workers to restart if requested:
-	workers []Worker
+	workers []workerSpec
 
-	hasWaiter *atomic.Bool
-	panicCh   chan any
+	// Stores the first panic that occurs until Wait is called,
+	// each cycle gets its own channel so late panics from the
+	// workers of a previous cycle are never reported again:
+	panicCh chan *PanicError
+
+	// All errors returned by the workers, only returned
+	// by Wait if the WithAllErrors option is set:
+	collectAllErrors bool
+	errs             *errorList
+
+	returnPanicAsError bool
+
+	// Used for restarting the workers started with GoSupervised:
+	restartStrategy RestartStrategy
+	sup             *supervisor
+
+	// Limits the restarts of both the group and its workers:
+	restarts *restartLimiter
+
+	shutdownTimeout   time.Duration
+	stuckWorkerStacks bool
+
+	// Limits the number of active workers, nil if there is no limit:
+	sem chan struct{}
+	// Set if a worker waiting for the limit was never started:
+	notStarted *atomic.Bool
 }
 
-func NewGroup(parentCtx context.Context) Group {
-	ctx, cancel := context.WithCancel(parentCtx)
+// GroupOption configures optional behaviors of a Group,
+// it should be passed as an argument to NewGroup.
+type GroupOption func(g *Group)
 
-	return Group{
-		g:         &errgroup.Group{},
-		ctx:       ctx,
-		parentCtx: parentCtx,
-		cancel:    cancel,
-		hasWaiter: &atomic.Bool{},
-		panicCh:   make(chan any),
+// WithAllErrors makes Group.Wait return a MultiError containing
+// the errors of every worker that failed instead of only the first one.
+func WithAllErrors() GroupOption {
+	return func(g *Group) {
+		g.collectAllErrors = true
 	}
 }
 
+// WithPanicAsError makes Group.Wait return a *PanicError when one
+// of the workers panics instead of panicking on the waiting Goroutine.
+func WithPanicAsError() GroupOption {
+	return func(g *Group) {
+		g.returnPanicAsError = true
+	}
+}
+
+// WithLimit limits the number of workers running at the same time,
+// once the limit is reached Group.Go blocks until one of them returns.
+//
+// If the group is canceled meanwhile Group.Go returns without starting
+// the worker, and unless another error is reported Group.Wait returns
+// an error wrapping both ErrWorkersNotStarted and the context error.
+//
+// The same limit applies to workers being restarted.
+func WithLimit(n int) GroupOption {
+	return func(g *Group) {
+		if n > 0 {
+			g.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+func NewGroup(parentCtx context.Context, opts ...GroupOption) Group {
+	baseCtx, cancelBase := context.WithCancel(parentCtx)
+	ctx, cancel := context.WithCancel(baseCtx)
+
+	g := Group{
+		g:           &errgroup.Group{},
+		ctx:         ctx,
+		parentCtx:   parentCtx,
+		cancel:      cancel,
+		baseCtx:     baseCtx,
+		cancelBase:  cancelBase,
+		cancelation: &cancelation{},
+		mux:         &sync.Mutex{},
+		panicCh:     make(chan *PanicError, 1),
+		errs:        &errorList{},
+		notStarted:  &atomic.Bool{},
+		restarts:    &restartLimiter{},
+	}
+	for _, opt := range opts {
+		opt(&g)
+	}
+	g.sup = newSupervisor(g.restartStrategy, g.restarts)
+
+	return g
+}
+
+type workerSpec struct {
+	// The name given to GoNamed, empty for unnamed workers:
+	name   string
+	policy RestartPolicy
+	fn     Worker
+}
+
 func (g *Group) Go(fn Worker) {
-	g.workers = append(g.workers, fn)
+	g.goSpec(workerSpec{fn: fn})
+}
+
+// GoNamed works like Go but also gives the worker a name, which
+// is made available to the worker via the WorkerName function,
+// used for wrapping the errors it returns in a *WorkerError and
+// for identifying the worker if it panics.
+//
+// If the group was created inside another named worker the
+// name will be prefixed by the name of the parent worker,
+// e.g. "ingest/parser".
+func (g *Group) GoNamed(name string, fn Worker) {
+	g.goSpec(workerSpec{name: name, fn: fn})
+}
+
+func (g *Group) goSpec(w workerSpec) {
+	g.workers = append(g.workers, w)
 
-	g.start(fn)
+	g.start(len(g.workers)-1, w)
 }
 
-func (g Group) start(fn Worker) {
+func (g Group) start(workerID int, w workerSpec) {
+	name := workerFullName(g.parentCtx, w.name, workerID)
+	ctx := g.ctx
+	if name != "" {
+		ctx = context.WithValue(ctx, ctxWorkerNameKey{}, name)
+	}
+
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		case <-g.ctx.Done():
+			g.notStarted.Store(true)
+			return
+		}
+
+		// The select picks a random case when both are ready:
+		if g.ctx.Err() != nil {
+			<-g.sem
+			g.notStarted.Store(true)
+			return
+		}
+	}
+
+	// Registering the worker before starting the Goroutine ensures
+	// the supervisor knows about it if a sibling restarts right away:
+	workerCtx, cancel := g.sup.started(ctx, workerID, name)
+
+	var tracked *goroutines.Goroutine
+	if goroutines.Tracking() {
+		tracked = goroutines.Track(workerLabel(name, workerID))
+	}
+
 	g.g.Go(func() error {
-		defer func() {
-			if r := recover(); r != nil {
+		defer tracked.Started()()
+
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+
+		if g.stuckWorkerStacks {
+			g.sup.setGoroutineID(workerID, goroutines.CurrentID())
+		}
+
+		for {
+			panicErr, err := runWorker(workerCtx, w.fn)
+			cancel()
+
+			decision, delay, tooManyRestartsErr := g.sup.exited(g.ctx, workerID, w.policy, err, panicErr)
+			switch decision {
+			case restartWorker:
+				if !waitBackoff(g.ctx, delay) {
+					return nil
+				}
+				workerCtx, cancel = g.sup.started(ctx, workerID, name)
+				continue
+			case stopWorker:
+				return nil
+			}
+
+			if tooManyRestartsErr != nil {
+				err, panicErr = tooManyRestartsErr, nil
+			}
+
+			if panicErr != nil {
 				g.cancel()
-				if g.hasWaiter.Load() {
-					r = fmt.Sprintf("%v\n%s", r, string(debug.Stack()))
-					g.panicCh <- r
-					return
+				panicErr.WorkerID = workerID
+				// Panics forwarded from subgroups keep the
+				// name of the worker inside the subgroup:
+				if panicErr.WorkerName == "" {
+					panicErr.WorkerName = name
 				}
-				panic(r)
+				select {
+				case g.panicCh <- panicErr:
+				default:
+					// Only the first panic is forwarded to Wait,
+					// the others are likely caused by the shutdown.
+				}
+				return nil
 			}
-		}()
 
-		err := fn(g.ctx)
-		if err != nil {
-			g.cancel()
-		}
-		if err == ErrStartGracefulShutdown {
-			return nil
+			if err != nil {
+				g.cancel()
+			}
+			if err == ErrStartGracefulShutdown {
+				return nil
+			}
+			if err != nil && name != "" {
+				err = wrapWorkerError(name, err)
+			}
+			if err != nil {
+				g.errs.add(err)
+			}
+			return err
 		}
-		return err
 	})
 }
 
+func runWorker(ctx context.Context, fn Worker) (panicErr *PanicError, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if forwarded, ok := r.(subGroupPanic); ok {
+				panicErr = forwarded.PanicError
+				return
+			}
+
+			panicErr = &PanicError{
+				Payload: r,
+				Stack:   string(debug.Stack()),
+			}
+		}
+	}()
+
+	return nil, fn(ctx)
+}
+
 func (g *Group) Wait() error {
+	return g.WaitContext(context.Background())
+}
+
+// WaitContext works like Wait but stops waiting when ctx is done,
+// in which case it cancels the workers and returns ctx.Err().
+//
+// If the WithShutdownTimeout option is set it will also wait
+// up to that timeout for the workers to return before returning,
+// and panics that happen meanwhile are still forwarded.
+func (g *Group) WaitContext(ctx context.Context) error {
 	defer func() {
+		g.resetBase()
 		g.resetGroup()
-		g.workers = []Worker{}
+		g.restarts.reset()
+		g.workers = []workerSpec{}
 	}()
-	g.hasWaiter.Store(true)
+
+	waitCtxDone := ctx.Done()
+	var waitCtxErr error
 
 restartTag:
-	select {
-	case err := <-g.waitCh():
-		if errors.Is(err, ErrRestartGroup) {
-			g.resetGroup()
+	waitCh := g.waitCh()
 
-			for _, worker := range g.workers {
-				g.start(worker)
+	// The shutdown timeout only starts after the context is canceled:
+	var ctxDone <-chan struct{}
+	var shutdownTimeoutCh <-chan time.Time
+	if g.shutdownTimeout > 0 {
+		ctxDone = g.ctx.Done()
+	}
+
+	for {
+		select {
+		case err := <-waitCh:
+			// A panicking worker might have returned before
+			// the select had the chance to read the panicCh:
+			select {
+			case panicErr := <-g.panicCh:
+				return g.handlePanic(panicErr)
+			default:
+			}
+
+			if waitCtxErr != nil {
+				return waitCtxErr
+			}
+
+			canceled, cancelCause := g.cancelState()
+			if cancelCause != nil {
+				return cancelCause
+			}
+
+			if errors.Is(err, ErrRestartGroup) {
+				if canceled {
+					// Restarting makes no sense after Cancel was called:
+					return nil
+				}
+
+				delay, tooManyRestartsErr := g.restarts.allow(ClockFromContext(g.parentCtx).Now(), err)
+				if tooManyRestartsErr != nil {
+					return tooManyRestartsErr
+				}
+				if !g.waitRestartBackoff(delay, waitCtxDone) {
+					if waitCtxErr := ctx.Err(); waitCtxErr != nil {
+						g.Cancel(nil)
+						return waitCtxErr
+					}
+
+					// The group was canceled during the backoff:
+					_, cancelCause := g.cancelState()
+					return cancelCause
+				}
+
+				g.resetGroup()
+
+				for i, worker := range g.workers {
+					g.start(i, worker)
+				}
+
+				goto restartTag
 			}
 
-			goto restartTag
+			if err != nil && g.collectAllErrors {
+				return MultiError{Errors: g.errs.list()}
+			}
+			if err == nil && g.notStarted.Load() {
+				return fmt.Errorf("%w: %w", ErrWorkersNotStarted, g.ctx.Err())
+			}
+			return err
+		case panicErr := <-g.panicCh:
+			return g.handlePanic(panicErr)
+		case <-waitCtxDone:
+			waitCtxDone = nil
+			waitCtxErr = ctx.Err()
+			g.Cancel(nil)
+			if g.shutdownTimeout <= 0 {
+				return waitCtxErr
+			}
+		case <-ctxDone:
+			ctxDone = nil
+			shutdownTimeoutCh = ClockFromContext(g.parentCtx).After(g.shutdownTimeout)
+		case <-shutdownTimeoutCh:
+			if waitCtxErr != nil {
+				return waitCtxErr
+			}
+			return g.shutdownTimeoutErr()
 		}
+	}
+}
 
-		return err
-	case panicPayload := <-g.panicCh:
-		panic(panicPayload)
+// waitRestartBackoff waits for the delay before restarting the group and
+// returns false if the group is canceled or waitCtxDone is closed meanwhile.
+func (g *Group) waitRestartBackoff(delay time.Duration, waitCtxDone <-chan struct{}) bool {
+	if delay <= 0 {
+		return g.baseCtx.Err() == nil
+	}
+
+	select {
+	case <-g.baseCtx.Done():
+		return false
+	case <-waitCtxDone:
+		return false
+	case <-ClockFromContext(g.parentCtx).After(delay):
+		return true
 	}
 }
 
+// WaitTimeout works like WaitContext but stops waiting after the timeout.
+func (g *Group) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := ClockFromContext(g.parentCtx).WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return g.WaitContext(ctx)
+}
+
+func (g *Group) handlePanic(panicErr *PanicError) error {
+	if g.returnPanicAsError {
+		return panicErr
+	}
+	if panicErr.WorkerName != "" {
+		panic(fmt.Sprintf("worker %s panicked: %v\n%s", panicErr.WorkerName, panicErr.Payload, panicErr.Stack))
+	}
+	panic(fmt.Sprintf("%v\n%s", panicErr.Payload, panicErr.Stack))
+}
+
 func (g *Group) resetGroup() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
 	g.g = &errgroup.Group{}
-	g.ctx, g.cancel = context.WithCancel(g.parentCtx)
+	g.errs = &errorList{}
+	g.notStarted = &atomic.Bool{}
+	g.sup = newSupervisor(g.restartStrategy, g.restarts)
+	g.panicCh = make(chan *PanicError, 1)
+	g.ctx, g.cancel = context.WithCancel(g.baseCtx)
+}
+
+func (g *Group) resetBase() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	g.cancelBase()
+	g.baseCtx, g.cancelBase = context.WithCancel(g.parentCtx)
+	*g.cancelation = cancelation{}
 }
 
 func (g *Group) SubGroup(workers ...Worker) {
-	g.Go(func(ctx context.Context) error {
-		subg := NewGroup(ctx)
+	g.Go(subGroupWorker(workers))
+}
+
+// SubGroupNamed works like SubGroup but names the subgroup, the
+// workers inside of it are then named after their position, e.g.
+// the fourth worker of a subgroup named "parser" will be "parser/3".
+func (g *Group) SubGroupNamed(name string, workers ...Worker) {
+	g.GoNamed(name, subGroupWorker(workers))
+}
+
+func subGroupWorker(workers []Worker) Worker {
+	return func(ctx context.Context) error {
+		subg := NewGroup(ctx, WithPanicAsError())
 		for _, worker := range workers {
 			subg.Go(worker)
 		}
-		return subg.Wait()
-	})
+
+		err := subg.Wait()
+
+		if panicErr, ok := err.(*PanicError); ok {
+			// Forwards the original panic to the parent group:
+			panic(subGroupPanic{panicErr})
+		}
+		return err
+	}
+}
+
+// subGroupPanic carries a panic from a subgroup to its parent group,
+// so the parent reports the original payload and stacktrace instead
+// of the ones of the worker running the subgroup.
+type subGroupPanic struct {
+	*PanicError
 }
 
 func (g Group) waitCh() chan error {
 	waitCh := make(chan error, 1)
+	tracked := goroutines.Track("Group.Wait")
 	go func() {
+		defer tracked.Started()()
 		waitCh <- g.g.Wait()
 	}()
 
 	return waitCh
 }
+
+// MultiError is returned by Group.Wait when the WithAllErrors
+// option is used, it contains the errors returned by each of
+// the failing workers in the order they were returned.
+//
+// It works with errors.Is and errors.As checking each of
+// the errors it contains.
+type MultiError struct {
+	Errors []error
+}
+
+func (m MultiError) Error() string {
+	if len(m.Errors) == 1 {
+		return m.Errors[0].Error()
+	}
+
+	msgs := make([]string, 0, len(m.Errors))
+	for _, err := range m.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d workers failed: %s", len(m.Errors), strings.Join(msgs, "; "))
+}
+
+func (m MultiError) Unwrap() []error {
+	return m.Errors
+}
+
+// PanicError is returned by Group.Wait when the WithPanicAsError
+// option is used and one of the workers panics.
+type PanicError struct {
+	// The value originally passed to panic():
+	Payload any
+
+	// The stacktrace of the Goroutine that panicked:
+	Stack string
+
+	// The position of the worker on the group, starting from 0,
+	// in the same order that they were passed to Group.Go:
+	WorkerID int
+
+	// The name of the worker, empty if it was not named,
+	// see Group.GoNamed for more details.
+	//
+	// Panics inside a SubGroup keep the full name of the
+	// worker inside the subgroup, e.g. "parser/3", if it
+	// has one, and the name of the subgroup otherwise:
+	WorkerName string
+}
+
+func (p *PanicError) Error() string {
+	if p.WorkerName != "" {
+		return fmt.Sprintf("worker %s panicked: %v", p.WorkerName, p.Payload)
+	}
+	return fmt.Sprintf("worker %d panicked: %v", p.WorkerID, p.Payload)
+}
+
+// Unwrap returns the panic payload if it is an error, so
+// errors.Is and errors.As can be used to check it.
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Payload.(error)
+	return err
+}
+
+type errorList struct {
+	mux  sync.Mutex
+	errs []error
+}
+
+func (e *errorList) add(err error) {
+	safe.Do(&e.mux, func() {
+		e.errs = append(e.errs, err)
+	})
+}
+
+func (e *errorList) list() []error {
+	var errs []error
+	safe.Do(&e.mux, func() {
+		errs = append(errs, e.errs...)
+	})
+	return errs
+}
@@ -2,11 +2,16 @@ package threads
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	tt "github.com/blackpointcyber/threads/internal/testtools"
+	"github.com/blackpointcyber/threads/safe"
 )
 
 func TestForkAndWait(t *testing.T) {
@@ -182,6 +187,32 @@ func TestGoAndWait(t *testing.T) {
 		tt.AssertDone(t, 10*time.Millisecond, isCtxCanceled)
 		tt.AssertContains(t, fmt.Sprint(panicPayload, stackTrace), "PanicHandler", "panickingWorker", "fakePanicPayload")
 	})
+
+	t.Run("should forward panics that occur before Wait is called", func(t *testing.T) {
+		g := NewGroup(ctx)
+
+		panicked := make(chan struct{})
+		g.Go(func(ctx context.Context) error {
+			defer close(panicked)
+			panic("fakeEarlyPanic")
+		})
+
+		isCtxCanceled := make(chan struct{})
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			close(isCtxCanceled)
+			return nil
+		})
+
+		// Make sure the panic happens before Wait is called:
+		tt.AssertDone(t, 10*time.Millisecond, panicked)
+		tt.AssertDone(t, 10*time.Millisecond, isCtxCanceled)
+
+		panicPayload, _ := tt.PanicHandler(func() {
+			g.Wait()
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakeEarlyPanic")
+	})
 }
 
 func panickingWorker(ctx context.Context) error {
@@ -300,3 +331,562 @@ func TestRestartGroup(t *testing.T) {
 		tt.AssertEqual(t, receivedClosureValues, []string{"initialState", "changedState"})
 	})
 }
+
+func TestWithAllErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return the errors of all failing workers", func(t *testing.T) {
+		fakeErr := fmt.Errorf("fakeErrMsg")
+
+		g := NewGroup(ctx, WithAllErrors())
+		g.Go(func(ctx context.Context) error {
+			return fakeErr
+		})
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return fmt.Errorf("secondErr: %w", ctx.Err())
+		})
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ErrStartGracefulShutdown
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "2 workers failed", "fakeErrMsg", "secondErr")
+
+		var multiErr MultiError
+		tt.AssertEqual(t, errors.As(err, &multiErr), true)
+		tt.AssertEqual(t, len(multiErr.Errors), 2)
+		tt.AssertEqual(t, multiErr.Errors[0], fakeErr)
+		tt.AssertEqual(t, errors.Is(err, fakeErr), true)
+		tt.AssertEqual(t, errors.Is(err, context.Canceled), true)
+	})
+
+	t.Run("should return nil if no workers fail", func(t *testing.T) {
+		g := NewGroup(ctx, WithAllErrors())
+		g.Go(func(ctx context.Context) error {
+			return nil
+		})
+		g.Go(func(ctx context.Context) error {
+			return ErrStartGracefulShutdown
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+	})
+
+	t.Run("should discard errors from before a restart", func(t *testing.T) {
+		g := NewGroup(ctx, WithAllErrors())
+
+		restarted := false
+		g.Go(func(ctx context.Context) error {
+			if !restarted {
+				restarted = true
+				return ErrRestartGroup
+			}
+			return fmt.Errorf("afterRestartErr")
+		})
+
+		err := g.Wait()
+
+		var multiErr MultiError
+		tt.AssertEqual(t, errors.As(err, &multiErr), true)
+		tt.AssertEqual(t, len(multiErr.Errors), 1)
+		tt.AssertErrContains(t, err, "afterRestartErr")
+	})
+}
+
+func TestWithPanicAsError(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return a PanicError instead of panicking", func(t *testing.T) {
+		g := NewGroup(ctx, WithPanicAsError())
+
+		isCtxCanceled := make(chan struct{})
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			close(isCtxCanceled)
+			return nil
+		})
+
+		// The function "panickingWorker" should appear on the stacktrace:
+		g.Go(panickingWorker)
+
+		var err error
+		panicPayload, _ := tt.PanicHandler(func() {
+			err = g.Wait()
+		})
+		tt.AssertEqual(t, panicPayload, nil)
+
+		var panicErr *PanicError
+		tt.AssertEqual(t, errors.As(err, &panicErr), true)
+		tt.AssertEqual(t, panicErr.Payload, "fakePanicPayload")
+		tt.AssertEqual(t, panicErr.WorkerID, 1)
+		tt.AssertContains(t, panicErr.Stack, "panickingWorker")
+		tt.AssertErrContains(t, err, "worker 1 panicked", "fakePanicPayload")
+
+		tt.AssertDone(t, 10*time.Millisecond, isCtxCanceled)
+	})
+
+	t.Run("should keep the payload unchanged when it is an error", func(t *testing.T) {
+		fakeErr := fmt.Errorf("fakeErrPayload")
+
+		g := NewGroup(ctx, WithPanicAsError())
+		g.Go(func(ctx context.Context) error {
+			panic(fakeErr)
+		})
+
+		err := g.Wait()
+		tt.AssertEqual(t, errors.Is(err, fakeErr), true)
+	})
+
+	t.Run("should forward the original panic from subgroups", func(t *testing.T) {
+		fakeErr := fmt.Errorf("fakeErrPayload")
+
+		g := NewGroup(ctx, WithPanicAsError())
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+		g.SubGroupNamed("parser",
+			func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			func(ctx context.Context) error {
+				panickingErrWorker(fakeErr)
+				return nil
+			},
+		)
+
+		err := g.Wait()
+
+		var panicErr *PanicError
+		tt.AssertEqual(t, errors.As(err, &panicErr), true)
+		tt.AssertEqual(t, panicErr.Payload, error(fakeErr))
+		tt.AssertEqual(t, errors.Is(err, fakeErr), true)
+		tt.AssertEqual(t, panicErr.WorkerID, 1)
+		tt.AssertEqual(t, panicErr.WorkerName, "parser/1")
+		tt.AssertContains(t, panicErr.Stack, "panickingErrWorker")
+	})
+
+	t.Run("should forward the original panic from unnamed subgroups", func(t *testing.T) {
+		g := NewGroup(ctx)
+		g.SubGroup(panickingWorker)
+
+		panicPayload, _ := tt.PanicHandler(func() {
+			g.Wait()
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakePanicPayload", "panickingWorker")
+
+		// The payload is reported only once, instead of wrapped by each group:
+		tt.AssertEqual(t, strings.Count(fmt.Sprint(panicPayload), "fakePanicPayload"), 1)
+	})
+}
+
+func panickingErrWorker(err error) {
+	panic(err)
+}
+
+func TestGoNamed(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should make the name available on the worker context", func(t *testing.T) {
+		var names []string
+
+		g := NewGroup(ctx)
+		g.GoNamed("fakeWorker", func(ctx context.Context) error {
+			names = append(names, WorkerName(ctx))
+			return nil
+		})
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+
+		g.Go(func(ctx context.Context) error {
+			names = append(names, WorkerName(ctx))
+			return nil
+		})
+		err = g.Wait()
+		tt.AssertNoErr(t, err)
+
+		tt.AssertEqual(t, names, []string{"fakeWorker", ""})
+	})
+
+	t.Run("should wrap the returned errors with the worker name", func(t *testing.T) {
+		fakeErr := fmt.Errorf("fakeErrMsg")
+
+		g := NewGroup(ctx)
+		g.GoNamed("fakeWorker", func(ctx context.Context) error {
+			return fakeErr
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "worker fakeWorker", "fakeErrMsg")
+		tt.AssertEqual(t, errors.Is(err, fakeErr), true)
+
+		var workerErr *WorkerError
+		tt.AssertEqual(t, errors.As(err, &workerErr), true)
+		tt.AssertEqual(t, workerErr.WorkerName, "fakeWorker")
+	})
+
+	t.Run("should still restart the group when named workers return ErrRestartGroup", func(t *testing.T) {
+		numCalls := 0
+
+		g := NewGroup(ctx)
+		g.GoNamed("fakeWorker", func(ctx context.Context) error {
+			numCalls++
+			if numCalls == 1 {
+				return ErrRestartGroup
+			}
+			return ErrStartGracefulShutdown
+		})
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, numCalls, 2)
+	})
+
+	t.Run("should identify named workers that panic", func(t *testing.T) {
+		g := NewGroup(ctx, WithPanicAsError())
+		g.GoNamed("fakeWorker", panickingWorker)
+
+		err := g.Wait()
+
+		var panicErr *PanicError
+		tt.AssertEqual(t, errors.As(err, &panicErr), true)
+		tt.AssertEqual(t, panicErr.WorkerName, "fakeWorker")
+		tt.AssertEqual(t, panicErr.Payload, "fakePanicPayload")
+		tt.AssertErrContains(t, err, "worker fakeWorker panicked")
+
+		g = NewGroup(ctx)
+		g.GoNamed("fakeWorker", panickingWorker)
+		panicPayload, _ := tt.PanicHandler(func() {
+			g.Wait()
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "worker fakeWorker panicked", "fakePanicPayload")
+	})
+
+	t.Run("should build hierarchical names for subgroups", func(t *testing.T) {
+		namesCh := make(chan string, 3)
+
+		g := NewGroup(ctx)
+		g.GoNamed("ingest", func(ctx context.Context) error {
+			subg := NewGroup(ctx)
+			subg.SubGroupNamed("parser",
+				func(ctx context.Context) error {
+					namesCh <- WorkerName(ctx)
+					return nil
+				},
+				func(ctx context.Context) error {
+					namesCh <- WorkerName(ctx)
+					return fmt.Errorf("fakeErrMsg")
+				},
+			)
+			subg.GoNamed("sender", func(ctx context.Context) error {
+				namesCh <- WorkerName(ctx)
+				return nil
+			})
+			return subg.Wait()
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "worker ingest/parser/1: fakeErrMsg")
+
+		close(namesCh)
+		names := map[string]bool{}
+		for name := range namesCh {
+			names[name] = true
+		}
+		tt.AssertEqual(t, names, map[string]bool{
+			"ingest/parser/0": true,
+			"ingest/parser/1": true,
+			"ingest/sender":   true,
+		})
+	})
+}
+
+func TestWaitContext(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should stop waiting and cancel the workers when the context is done", func(t *testing.T) {
+		releaseCh := make(chan struct{})
+		defer close(releaseCh)
+
+		isCtxCanceled := make(chan struct{})
+
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			close(isCtxCanceled)
+			return ignoringCtxWorker(releaseCh)
+		})
+
+		waitCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := g.WaitContext(waitCtx)
+		tt.AssertEqual(t, err, context.Canceled)
+		tt.AssertDone(t, 10*time.Millisecond, isCtxCanceled)
+	})
+
+	t.Run("should behave like Wait if the context is not done", func(t *testing.T) {
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+
+		err := g.WaitContext(ctx)
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+	})
+
+	t.Run("should still forward panics while waiting", func(t *testing.T) {
+		g := NewGroup(ctx)
+		g.Go(panickingWorker)
+
+		panicPayload, _ := tt.PanicHandler(func() {
+			g.WaitContext(ctx)
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakePanicPayload")
+	})
+
+	t.Run("should drain the workers if a shutdown timeout is set", func(t *testing.T) {
+		var returned bool
+
+		g := NewGroup(ctx, WithShutdownTimeout(time.Second))
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Millisecond)
+			returned = true
+			return nil
+		})
+
+		waitCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := g.WaitContext(waitCtx)
+		tt.AssertEqual(t, err, context.Canceled)
+		tt.AssertEqual(t, returned, true)
+	})
+}
+
+func TestWaitTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should stop waiting after the timeout", func(t *testing.T) {
+		g := NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+
+		err := g.WaitTimeout(time.Millisecond)
+		tt.AssertEqual(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("should not report late panics on the next call to Wait", func(t *testing.T) {
+		g := NewGroup(ctx)
+
+		releaseCh := make(chan struct{})
+		panicked := make(chan struct{})
+		g.Go(func(ctx context.Context) error {
+			defer close(panicked)
+			<-releaseCh
+			panic("fakeLatePanic")
+		})
+
+		err := g.WaitTimeout(time.Millisecond)
+		tt.AssertEqual(t, err, context.DeadlineExceeded)
+
+		// The worker panics after the first Wait has returned:
+		close(releaseCh)
+		tt.AssertDone(t, 10*time.Millisecond, panicked)
+
+		g.Go(func(ctx context.Context) error {
+			return nil
+		})
+		tt.AssertNoErr(t, g.Wait())
+	})
+}
+
+func TestWithLimit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should not run more than n workers at the same time", func(t *testing.T) {
+		var counter concurrencyCounter
+		worker := func(ctx context.Context) error {
+			defer counter.enter()()
+			time.Sleep(100 * time.Microsecond)
+			return nil
+		}
+
+		g := NewGroup(ctx, WithLimit(2))
+		for i := 0; i < 10; i++ {
+			g.Go(worker)
+		}
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, counter.max(), 2)
+	})
+
+	t.Run("should still cancel the group on errors", func(t *testing.T) {
+		var secondWorkerCtxErr error
+
+		g := NewGroup(ctx, WithLimit(2))
+		g.Go(func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			secondWorkerCtxErr = ctx.Err()
+			return nil
+		})
+
+		err := g.Wait()
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertErrContains(t, secondWorkerCtxErr, "context canceled")
+	})
+
+	t.Run("should not start the waiting workers after the group is canceled", func(t *testing.T) {
+		var numCalls int32
+		worker := func(ctx context.Context) error {
+			atomic.AddInt32(&numCalls, 1)
+			return nil
+		}
+
+		err := ForkAndWaitN(ctx, 1,
+			func(ctx context.Context) error {
+				return fmt.Errorf("fakeErrMsg")
+			},
+			worker,
+			worker,
+		)
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(0))
+	})
+
+	t.Run("should stop blocking Go when the group is canceled", func(t *testing.T) {
+		g := NewGroup(ctx, WithLimit(1))
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+
+		var numCalls int32
+		returned := make(chan struct{})
+		go func() {
+			defer close(returned)
+			g.Go(func(ctx context.Context) error {
+				atomic.AddInt32(&numCalls, 1)
+				return nil
+			})
+		}()
+
+		tt.AssertNotDone(t, returned)
+		g.Cancel(nil)
+		tt.AssertDone(t, 100*time.Millisecond, returned)
+
+		err := g.Wait()
+		tt.AssertEqual(t, errors.Is(err, ErrWorkersNotStarted), true, err)
+		tt.AssertEqual(t, errors.Is(err, context.Canceled), true, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(0))
+	})
+
+	t.Run("should report the workers not started because the context was canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		var numCalls int32
+		err := ForkAndWaitN(ctx, 1, func(ctx context.Context) error {
+			atomic.AddInt32(&numCalls, 1)
+			return nil
+		})
+		tt.AssertEqual(t, errors.Is(err, ErrWorkersNotStarted), true, err)
+		tt.AssertEqual(t, errors.Is(err, context.Canceled), true, err)
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(0))
+	})
+
+	t.Run("should still forward panics", func(t *testing.T) {
+		g := NewGroup(ctx, WithLimit(1))
+		g.Go(panickingWorker)
+		g.Go(func(ctx context.Context) error {
+			return nil
+		})
+
+		panicPayload, _ := tt.PanicHandler(func() {
+			g.Wait()
+		})
+		tt.AssertContains(t, fmt.Sprint(panicPayload), "fakePanicPayload")
+	})
+
+	t.Run("should apply the limit to restarted workers", func(t *testing.T) {
+		var counter concurrencyCounter
+		var numCalls int32
+		worker := func(ctx context.Context) error {
+			defer counter.enter()()
+			time.Sleep(100 * time.Microsecond)
+			if atomic.AddInt32(&numCalls, 1) == 1 {
+				return ErrRestartGroup
+			}
+			return nil
+		}
+
+		g := NewGroup(ctx, WithLimit(1))
+		g.Go(worker)
+		g.Go(worker)
+
+		err := g.Wait()
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, counter.max(), 1)
+		// The second worker is only started after the restart, since
+		// the group was already canceled when its slot was released:
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(3))
+	})
+}
+
+func TestForkAndWaitN(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should run all workers with at most n at the same time", func(t *testing.T) {
+		var counter concurrencyCounter
+		var numCalls int32
+		worker := func(ctx context.Context) error {
+			defer counter.enter()()
+			atomic.AddInt32(&numCalls, 1)
+			time.Sleep(100 * time.Microsecond)
+			return nil
+		}
+
+		err := ForkAndWaitN(ctx, 1, worker, worker, worker)
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, counter.max(), 1)
+		tt.AssertEqual(t, atomic.LoadInt32(&numCalls), int32(3))
+	})
+}
+
+// concurrencyCounter tracks the max number of
+// Goroutines running a section at the same time.
+type concurrencyCounter struct {
+	mux       sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (c *concurrencyCounter) enter() (exit func()) {
+	safe.Do(&c.mux, func() {
+		c.active++
+		if c.active > c.maxActive {
+			c.maxActive = c.active
+		}
+	})
+
+	return func() {
+		safe.Do(&c.mux, func() {
+			c.active--
+		})
+	}
+}
+
+func (c *concurrencyCounter) max() int {
+	return safe.Get(&c.mux, &c.maxActive)
+}
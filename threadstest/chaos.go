@@ -0,0 +1,114 @@
+package threadstest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blackpointcyber/threads"
+)
+
+// Chaos wraps a worker so that the fault is injected on the calls
+// selected by the trigger, the other calls run the worker normally.
+//
+// Each call to the returned worker counts as an iteration, so wrapping the
+// doWork function of a PeriodicWorker injects faults on its iterations and
+// wrapping a worker passed to a Group injects them on its restarts:
+//
+//	g.Go(threadstest.Chaos(MyWorker, threadstest.OnIteration(3), threadstest.Panic("boom")))
+func Chaos(worker threads.Worker, trigger Trigger, fault Fault) threads.Worker {
+	return func(ctx context.Context) error {
+		if trigger() {
+			return fault(ctx, worker)
+		}
+		return worker(ctx)
+	}
+}
+
+// Trigger decides whether the fault should be injected on each
+// call of a worker wrapped by Chaos, it might be called
+// concurrently if the wrapped worker is.
+type Trigger func() bool
+
+// OnIteration triggers the fault on the given iterations,
+// counting from 1 for the first call of the worker.
+func OnIteration(iterations ...int) Trigger {
+	selected := map[int64]bool{}
+	for _, i := range iterations {
+		selected[int64(i)] = true
+	}
+
+	var numCalls int64
+	return func() bool {
+		return selected[atomic.AddInt64(&numCalls, 1)]
+	}
+}
+
+// Randomly triggers the fault on each iteration with the given
+// probability, from 0 to 1, using a random source initialized
+// with the seed, so the same seed always triggers the fault
+// on the same iterations.
+func Randomly(probability float64, seed int64) Trigger {
+	var mux sync.Mutex
+	random := rand.New(rand.NewSource(seed))
+
+	return func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+
+		return random.Float64() < probability
+	}
+}
+
+// Fault replaces the call to the worker on the iterations selected
+// by the trigger, the worker is passed so faults that only disturb
+// the call can still run it.
+type Fault func(ctx context.Context, worker threads.Worker) error
+
+// Panic makes the worker panic with the given payload.
+func Panic(payload interface{}) Fault {
+	return func(ctx context.Context, worker threads.Worker) error {
+		panic(payload)
+	}
+}
+
+// ReturnError makes the worker return the given error without running.
+func ReturnError(err error) Fault {
+	return func(ctx context.Context, worker threads.Worker) error {
+		return err
+	}
+}
+
+// RestartGroup makes the worker return threads.ErrRestartGroup without running.
+func RestartGroup() Fault {
+	return ReturnError(threads.ErrRestartGroup)
+}
+
+// Hang blocks for d ignoring the cancellation of the context before
+// running the worker, like a worker stuck on a call that doesn't
+// support contexts, which is useful for testing shutdown timeouts.
+//
+// The time is measured with the Clock on the context.
+func Hang(d time.Duration) Fault {
+	return func(ctx context.Context, worker threads.Worker) error {
+		threads.ClockFromContext(ctx).Sleep(d)
+		return worker(ctx)
+	}
+}
+
+// DelayStart waits for d before running the worker, if the context is
+// cancelled in the meantime it returns nil without running it.
+//
+// The time is measured with the Clock on the context.
+func DelayStart(d time.Duration) Fault {
+	return func(ctx context.Context, worker threads.Worker) error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-threads.ClockFromContext(ctx).After(d):
+		}
+		return worker(ctx)
+	}
+}
@@ -0,0 +1,136 @@
+package threadstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blackpointcyber/threads"
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestChaos(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should forward the injected panic on the selected iteration", func(t *testing.T) {
+		var numCalls int
+		g := threads.NewGroup(ctx, threads.WithPanicAsError())
+		g.GoNamed("flaky", Chaos(func(ctx context.Context) error {
+			numCalls++
+			return threads.ErrRestartGroup
+		}, OnIteration(2), Panic("fakePanicPayload")))
+
+		err := g.Wait()
+
+		var panicErr *threads.PanicError
+		tt.AssertEqual(t, errors.As(err, &panicErr), true, err)
+		tt.AssertEqual(t, panicErr.Payload, "fakePanicPayload")
+		tt.AssertEqual(t, panicErr.WorkerName, "flaky")
+		tt.AssertEqual(t, numCalls, 1)
+	})
+
+	t.Run("should restart the group on the selected iteration", func(t *testing.T) {
+		var numCalls int
+		g := threads.NewGroup(ctx)
+		g.Go(Chaos(func(ctx context.Context) error {
+			numCalls++
+			return nil
+		}, OnIteration(1, 2), RestartGroup()))
+
+		tt.AssertNoErr(t, g.Wait())
+		tt.AssertEqual(t, numCalls, 1)
+	})
+
+	t.Run("should return the injected error and cancel the other workers", func(t *testing.T) {
+		canceledCh := make(chan struct{})
+		g := threads.NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			close(canceledCh)
+			return nil
+		})
+		g.Go(Chaos(func(ctx context.Context) error {
+			return nil
+		}, OnIteration(1), ReturnError(fmt.Errorf("fakeErrMsg"))))
+
+		tt.AssertErrContains(t, g.Wait(), "fakeErrMsg")
+		tt.AssertDone(t, time.Second, canceledCh)
+	})
+
+	t.Run("should trigger on the same iterations for the same seed", func(t *testing.T) {
+		triggered := func(trigger Trigger) []bool {
+			calls := make([]bool, 100)
+			for i := range calls {
+				calls[i] = trigger()
+			}
+			return calls
+		}
+
+		numTriggered := func(calls []bool) (n int) {
+			for _, c := range calls {
+				if c {
+					n++
+				}
+			}
+			return n
+		}
+
+		calls := triggered(Randomly(0.5, 42))
+		tt.AssertEqual(t, triggered(Randomly(0.5, 42)), calls)
+		tt.AssertNotEqual(t, triggered(Randomly(0.5, 43)), calls)
+		tt.AssertEqual(t, numTriggered(calls) > 0 && numTriggered(calls) < 100, true)
+
+		tt.AssertEqual(t, numTriggered(triggered(Randomly(0, 42))), 0)
+		tt.AssertEqual(t, numTriggered(triggered(Randomly(1, 42))), 100)
+	})
+
+	t.Run("should hang past the cancellation", func(t *testing.T) {
+		g := threads.NewGroup(ctx, threads.WithShutdownTimeout(10*time.Millisecond))
+		g.GoNamed("stuck", Chaos(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}, OnIteration(1), Hang(100*time.Millisecond)))
+		g.Go(func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		})
+
+		err := g.Wait()
+		tt.AssertEqual(t, errors.Is(err, threads.ErrShutdownTimeout), true, err)
+		tt.AssertErrContains(t, err, "stuck", "fakeErrMsg")
+	})
+
+	t.Run("should delay the start of the worker", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		ctx := clock.Context(ctx)
+
+		runCh := make(chan struct{})
+		worker := Chaos(func(ctx context.Context) error {
+			close(runCh)
+			return nil
+		}, OnIteration(1), DelayStart(time.Minute))
+
+		doneCh := make(chan error)
+		go func() {
+			doneCh <- worker(ctx)
+		}()
+
+		clock.BlockUntilWaiters(1)
+		tt.AssertNotDone(t, runCh)
+
+		clock.Advance(time.Minute)
+		tt.AssertNoErr(t, <-doneCh)
+		tt.AssertDone(t, time.Second, runCh)
+	})
+
+	t.Run("should not start the delayed worker if the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := Chaos(func(ctx context.Context) error {
+			return fmt.Errorf("the worker should not run")
+		}, OnIteration(1), DelayStart(time.Hour))(ctx)
+		tt.AssertNoErr(t, err)
+	})
+}
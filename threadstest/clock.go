@@ -0,0 +1,410 @@
+// Package threadstest provides helpers for testing code
+// that uses the threads library.
+package threadstest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blackpointcyber/threads"
+	"github.com/blackpointcyber/threads/internal/goroutines"
+)
+
+// FakeClock is a threads.Clock whose time only moves when
+// Advance or Set are called, so tests of periodic schedules
+// can run without real sleeps.
+//
+// Use Context for making the helpers of the threads
+// library read the time from it:
+//
+//	clock := threadstest.NewFakeClock(time.Now())
+//	ctx = clock.Context(ctx)
+//
+// Just like with the time package, the channels returned
+// by After keep a timer pending until they fire, even if
+// no one is reading from them anymore, but such timers are
+// not counted by BlockUntilWaiters.
+type FakeClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+// NewFakeClock returns a FakeClock stopped at the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+// Context returns a copy of ctx that makes all the
+// helpers of the threads library use this clock.
+func (c *FakeClock) Context(ctx context.Context) context.Context {
+	return threads.ContextWithClock(ctx, c)
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+// Since returns the fake time elapsed since t.
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// After returns a channel that receives the fake time
+// once the clock is advanced by at least d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Sleep blocks until the clock is advanced by at least d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// NewTimer returns a timer that fires once the
+// clock is advanced by at least d.
+func (c *FakeClock) NewTimer(d time.Duration) threads.Timer {
+	t := &fakeTimer{
+		clock:       c,
+		goroutineID: goroutines.CurrentID(),
+		ch:          make(chan time.Time, 1),
+	}
+	t.fire = t.send
+	t.Reset(d)
+	return t
+}
+
+// NewTicker returns a ticker that fires every time the clock
+// is advanced past a multiple of d, it panics if d <= 0.
+func (c *FakeClock) NewTicker(d time.Duration) threads.Ticker {
+	if d <= 0 {
+		panic("threadstest: non-positive interval for NewTicker")
+	}
+
+	t := &fakeTimer{
+		clock:       c,
+		goroutineID: goroutines.CurrentID(),
+		ch:          make(chan time.Time, 1),
+		period:      d,
+	}
+	t.fire = t.send
+	t.Reset(d)
+	return fakeTicker{t}
+}
+
+// WithTimeout is the equivalent of context.WithTimeout,
+// but the timeout is measured on the fake time.
+func (c *FakeClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return c.WithDeadline(parent, c.Now().Add(d))
+}
+
+// WithDeadline is the equivalent of context.WithDeadline,
+// but the deadline is compared with the fake time.
+//
+// Contexts derived from the returned one also report
+// context.DeadlineExceeded once the fake deadline expires.
+func (c *FakeClock) WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	ctx := &deadlineCtx{
+		Context:  parent,
+		deadline: deadline,
+		done:     make(chan struct{}),
+	}
+
+	t := &fakeTimer{
+		clock:    c,
+		deadline: deadline,
+		fire: func(time.Time) {
+			ctx.cancel(context.DeadlineExceeded)
+		},
+	}
+	cancel := func() {
+		t.Stop()
+		ctx.cancel(context.Canceled)
+	}
+
+	if err := parent.Err(); err != nil {
+		ctx.cancel(err)
+		return ctx, cancel
+	}
+	if parent.Done() != nil {
+		go func() {
+			select {
+			case <-parent.Done():
+				t.Stop()
+				ctx.cancel(parent.Err())
+			case <-ctx.done:
+			}
+		}()
+	}
+
+	c.mux.Lock()
+	expired := !deadline.After(c.now)
+	if !expired {
+		c.schedule(t)
+	}
+	c.mux.Unlock()
+
+	if expired {
+		t.fire(deadline)
+	}
+
+	return ctx, cancel
+}
+
+// Advance moves the clock forward by d, firing all the timers
+// that expire in the meantime in the order of their deadlines.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to the given time, firing all the timers that
+// expire until then in the order of their deadlines.
+//
+// Moving the clock backwards doesn't fire any timers.
+func (c *FakeClock) Set(now time.Time) {
+	for {
+		c.mux.Lock()
+		t := c.nextExpired(now)
+		if t == nil {
+			c.now = now
+			c.mux.Unlock()
+			return
+		}
+
+		// Each timer sees the time it was scheduled to fire at:
+		if t.deadline.After(c.now) {
+			c.now = t.deadline
+		}
+		firedAt := c.now
+
+		c.unschedule(t)
+		if t.period > 0 {
+			t.deadline = t.deadline.Add(t.period)
+			c.schedule(t)
+		}
+		c.mux.Unlock()
+
+		t.fire(firedAt)
+	}
+}
+
+// BlockUntilWaiters blocks until at least n goroutines are waiting on
+// the clock, i.e. blocked on Sleep, or on a select or channel receive
+// while a timer they created with After, NewTimer or NewTicker is pending.
+//
+// Each goroutine is counted once, and the timers abandoned by goroutines
+// that already returned, e.g. an After on a select that returned on
+// ctx.Done(), are not counted, neither are the deadlines of the contexts
+// from WithTimeout and WithDeadline.
+//
+// It is useful for making sure the code being tested is
+// already waiting before advancing the clock.
+func (c *FakeClock) BlockUntilWaiters(n int) {
+	for {
+		c.mux.Lock()
+		owners := map[int64]bool{}
+		for _, t := range c.timers {
+			if t.goroutineID != 0 {
+				owners[t.goroutineID] = true
+			}
+		}
+		changed := c.changed
+		c.mux.Unlock()
+
+		if len(owners) < n {
+			<-changed
+			continue
+		}
+
+		numWaiters := 0
+		for _, stack := range goroutines.Stacks(owners) {
+			if goroutines.WaitingOnChannel(stack) {
+				numWaiters++
+			}
+		}
+		if numWaiters >= n {
+			return
+		}
+
+		// The owners of the timers might not be blocked yet:
+		select {
+		case <-changed:
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// PendingTimers returns how long until each of the pending
+// timers on the clock fires, sorted from the earliest.
+func (c *FakeClock) PendingTimers() []time.Duration {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	pending := make([]time.Duration, 0, len(c.timers))
+	for _, t := range c.timers {
+		pending = append(pending, t.deadline.Sub(c.now))
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i] < pending[j]
+	})
+	return pending
+}
+
+// nextExpired returns the pending timer with the earliest
+// deadline up to the given time, or nil if there is none.
+//
+// The caller must hold c.mux.
+func (c *FakeClock) nextExpired(until time.Time) *fakeTimer {
+	var next *fakeTimer
+	for _, t := range c.timers {
+		if t.deadline.After(until) {
+			continue
+		}
+		if next == nil || t.deadline.Before(next.deadline) {
+			next = t
+		}
+	}
+	return next
+}
+
+// The caller must hold c.mux.
+func (c *FakeClock) schedule(t *fakeTimer) {
+	c.timers = append(c.timers, t)
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// unschedule removes the timer from the pending ones and
+// reports whether it was pending. The caller must hold c.mux.
+func (c *FakeClock) unschedule(t *fakeTimer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	// The goroutine that created the timer,
+	// zero for the deadlines of contexts:
+	goroutineID int64
+	deadline    time.Time
+	period      time.Duration
+	ch          chan time.Time
+	fire        func(now time.Time)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mux.Lock()
+	wasPending := c.unschedule(t)
+	t.deadline = c.now.Add(d)
+	expired := d <= 0
+	if !expired {
+		c.schedule(t)
+	}
+	now := c.now
+	c.mux.Unlock()
+
+	if expired {
+		t.fire(now)
+	}
+	return wasPending
+}
+
+// send never blocks, so just like with the time package
+// the ticks are dropped if no one is reading them.
+func (t *fakeTimer) send(now time.Time) {
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("threadstest: non-positive interval for Ticker.Reset")
+	}
+
+	t.clock.mux.Lock()
+	t.period = d
+	t.clock.mux.Unlock()
+
+	t.fakeTimer.Reset(d)
+}
+
+// deadlineCtx is canceled with context.DeadlineExceeded when the
+// fake deadline expires. It has its own done channel instead of
+// wrapping a context.WithCancel, since the children of a cancel
+// context would be canceled directly with context.Canceled, while
+// the children of an unknown context are canceled with its Err().
+type deadlineCtx struct {
+	context.Context
+
+	deadline time.Time
+	done     chan struct{}
+
+	mux sync.Mutex
+	err error
+}
+
+func (ctx *deadlineCtx) Deadline() (time.Time, bool) {
+	return ctx.deadline, true
+}
+
+func (ctx *deadlineCtx) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *deadlineCtx) Err() error {
+	ctx.mux.Lock()
+	defer ctx.mux.Unlock()
+
+	return ctx.err
+}
+
+// cancel keeps the first error, e.g. the error of the
+// parent if it was cancelled before the deadline expired.
+func (ctx *deadlineCtx) cancel(err error) {
+	ctx.mux.Lock()
+	defer ctx.mux.Unlock()
+
+	if ctx.err != nil {
+		return
+	}
+	ctx.err = err
+	close(ctx.done)
+}
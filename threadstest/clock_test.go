@@ -0,0 +1,237 @@
+package threadstest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blackpointcyber/threads"
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func assertNotFired(t *testing.T, ch <-chan time.Time) {
+	select {
+	case now := <-ch:
+		t.Fatalf("unexpected timer fired at %v", now)
+	default:
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	ctx := context.Background()
+	start := tt.ParseTime(t, "2024-01-01T00:00:00Z")
+
+	t.Run("should only move when advanced", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		tt.AssertEqual(t, clock.Now(), start)
+
+		clock.Advance(time.Hour)
+		tt.AssertEqual(t, clock.Now(), start.Add(time.Hour))
+		tt.AssertEqual(t, clock.Since(start), time.Hour)
+
+		clock.Set(start)
+		tt.AssertEqual(t, clock.Now(), start)
+	})
+
+	t.Run("should fire the timers in the order of their deadlines", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		hourCh := clock.After(time.Hour)
+		minuteCh := clock.After(time.Minute)
+		dayCh := clock.After(24 * time.Hour)
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{time.Minute, time.Hour, 24 * time.Hour})
+
+		clock.Advance(2 * time.Hour)
+		tt.AssertEqual(t, <-minuteCh, start.Add(time.Minute))
+		tt.AssertEqual(t, <-hourCh, start.Add(time.Hour))
+		assertNotFired(t, dayCh)
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{22 * time.Hour})
+
+		clock.Set(start.Add(24 * time.Hour))
+		tt.AssertEqual(t, <-dayCh, start.Add(24*time.Hour))
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{})
+	})
+
+	t.Run("should fire right away if the duration is not positive", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		tt.AssertEqual(t, <-clock.After(0), start)
+		clock.Sleep(-time.Second)
+	})
+
+	t.Run("should stop and reset timers", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		timer := clock.NewTimer(time.Minute)
+		tt.AssertEqual(t, timer.Stop(), true)
+		tt.AssertEqual(t, timer.Stop(), false)
+
+		clock.Advance(time.Hour)
+		assertNotFired(t, timer.C())
+
+		tt.AssertEqual(t, timer.Reset(time.Minute), false)
+		tt.AssertEqual(t, timer.Reset(2*time.Minute), true)
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{2 * time.Minute})
+
+		clock.Advance(2 * time.Minute)
+		tt.AssertEqual(t, <-timer.C(), start.Add(time.Hour+2*time.Minute))
+	})
+
+	t.Run("should tick on every interval and drop the ticks that are not read", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		ticker := clock.NewTicker(time.Minute)
+		clock.Advance(time.Minute)
+		tt.AssertEqual(t, <-ticker.C(), start.Add(time.Minute))
+
+		clock.Advance(3 * time.Minute)
+		tt.AssertEqual(t, <-ticker.C(), start.Add(2*time.Minute))
+		assertNotFired(t, ticker.C())
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{time.Minute})
+
+		ticker.Reset(time.Hour)
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{time.Hour})
+
+		ticker.Stop()
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{})
+	})
+
+	t.Run("should expire contexts on the fake time", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		timeoutCtx, cancel := clock.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		deadline, ok := timeoutCtx.Deadline()
+		tt.AssertEqual(t, ok, true)
+		tt.AssertEqual(t, deadline, start.Add(time.Minute))
+
+		clock.Advance(time.Second)
+		tt.AssertEqual(t, timeoutCtx.Err(), nil)
+
+		clock.Advance(time.Minute)
+		tt.AssertDone(t, time.Second, timeoutCtx.Done())
+		tt.AssertEqual(t, timeoutCtx.Err(), context.DeadlineExceeded)
+
+		expiredCtx, cancel := clock.WithDeadline(ctx, start)
+		defer cancel()
+		tt.AssertEqual(t, expiredCtx.Err(), context.DeadlineExceeded)
+	})
+
+	t.Run("should report the expired deadline on the derived contexts", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		timeoutCtx, cancel := clock.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		childCtx, cancelChild := context.WithCancel(timeoutCtx)
+		defer cancelChild()
+		valueCtx := context.WithValue(childCtx, struct{}{}, "fakeValue")
+
+		clock.Advance(time.Minute)
+		tt.AssertDone(t, time.Second, childCtx.Done())
+		tt.AssertEqual(t, childCtx.Err(), context.DeadlineExceeded)
+		tt.AssertEqual(t, valueCtx.Err(), context.DeadlineExceeded)
+	})
+
+	t.Run("should be cancelled together with the parent context", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		parentCtx, cancelParent := context.WithCancel(ctx)
+		timeoutCtx, cancel := clock.WithTimeout(parentCtx, time.Minute)
+		defer cancel()
+
+		cancelParent()
+		tt.AssertDone(t, time.Second, timeoutCtx.Done())
+		tt.AssertEqual(t, timeoutCtx.Err(), context.Canceled)
+
+		// The deadline has no effect anymore:
+		clock.Advance(time.Minute)
+		tt.AssertEqual(t, timeoutCtx.Err(), context.Canceled)
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{})
+	})
+
+	t.Run("should stop waiting for the deadline when the context is cancelled", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		timeoutCtx, cancel := clock.WithTimeout(ctx, time.Minute)
+		tt.AssertEqual(t, len(clock.PendingTimers()), 1)
+
+		cancel()
+		tt.AssertEqual(t, timeoutCtx.Err(), context.Canceled)
+		tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{})
+	})
+
+	t.Run("should block until there are enough waiters", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		doneCh := make(chan struct{})
+		go func() {
+			defer close(doneCh)
+			clock.BlockUntilWaiters(2)
+		}()
+
+		go clock.Sleep(time.Second)
+		clock.BlockUntilWaiters(1)
+		tt.AssertNotDone(t, doneCh)
+
+		go clock.Sleep(time.Second)
+		tt.AssertDone(t, time.Second, doneCh)
+
+		// Releases the sleeping goroutines:
+		clock.Advance(time.Second)
+	})
+
+	t.Run("should not count the timers abandoned by their goroutines", func(t *testing.T) {
+		clock := NewFakeClock(start)
+
+		abandonedCh := make(chan struct{})
+		go func() {
+			defer close(abandonedCh)
+			clock.After(time.Second)
+		}()
+		<-abandonedCh
+		tt.AssertEqual(t, len(clock.PendingTimers()), 1)
+
+		doneCh := make(chan struct{})
+		go func() {
+			defer close(doneCh)
+			clock.BlockUntilWaiters(1)
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		tt.AssertNotDone(t, doneCh)
+
+		go clock.Sleep(time.Second)
+		tt.AssertDone(t, time.Second, doneCh)
+
+		clock.Advance(time.Second)
+	})
+
+	t.Run("should drive a PeriodicWorker without real sleeps", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		ctx, cancel := context.WithCancel(clock.Context(ctx))
+		defer cancel()
+
+		runCh := make(chan time.Time)
+		doneCh := make(chan error)
+		go func() {
+			doneCh <- threads.PeriodicWorker(time.Hour, func(ctx context.Context) error {
+				runCh <- threads.ClockFromContext(ctx).Now()
+				return nil
+			})(ctx)
+		}()
+
+		tt.AssertEqual(t, <-runCh, start)
+
+		for i := 1; i <= 3; i++ {
+			clock.BlockUntilWaiters(1)
+			tt.AssertEqual(t, clock.PendingTimers(), []time.Duration{time.Hour})
+
+			clock.Advance(time.Hour)
+			tt.AssertEqual(t, <-runCh, start.Add(time.Duration(i)*time.Hour))
+		}
+
+		cancel()
+		tt.AssertNoErr(t, <-doneCh)
+	})
+}
@@ -0,0 +1,91 @@
+package threadstest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blackpointcyber/threads/internal/goroutines"
+)
+
+// How long the goroutines have to exit after the test finishes:
+var leakTimeout = time.Second
+
+// VerifyNoLeaks fails the test if any of the goroutines started by
+// the threads library during the test are still running after it
+// finishes, including the cleanup functions registered after
+// VerifyNoLeaks is called.
+//
+// It covers the workers started with Group.Go and its variants,
+// including the ones inside of SubGroups, and the goroutines started
+// by Group.Wait and Group.Shutdown. The report lists the name and
+// the stack of each of them:
+//
+//	func TestMyService(t *testing.T) {
+//		threadstest.VerifyNoLeaks(t)
+//		...
+//	}
+//
+// The goroutines are tracked on the whole process, so it
+// shouldn't be used on tests running with t.Parallel.
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+
+	// Goroutines that leaked on previous tests are not reported again:
+	before := goroutines.Tracked()
+	stopTracking := goroutines.EnableTracking()
+
+	t.Cleanup(func() {
+		defer stopTracking()
+
+		leaked := waitLeaks(before, leakTimeout)
+		if len(leaked) > 0 {
+			t.Errorf("%s", leaksReport(leaked))
+		}
+	})
+}
+
+// waitLeaks waits until all the goroutines tracked after the snapshot
+// return, or until the timeout expires, in which case it returns the
+// ones still running.
+func waitLeaks(before map[*goroutines.Goroutine]goroutines.Info, timeout time.Duration) []goroutines.Info {
+	deadline := time.Now().Add(timeout)
+	for {
+		var leaked []goroutines.Info
+		for g, info := range goroutines.Tracked() {
+			if _, ok := before[g]; !ok {
+				leaked = append(leaked, info)
+			}
+		}
+
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func leaksReport(leaked []goroutines.Info) string {
+	sort.Slice(leaked, func(i, j int) bool {
+		return leaked[i].ID < leaked[j].ID
+	})
+
+	ids := map[int64]bool{}
+	for _, info := range leaked {
+		ids[info.ID] = true
+	}
+	stacks := goroutines.Stacks(ids)
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "%d goroutines started by the threads library are still running:", len(leaked))
+	for _, info := range leaked {
+		stack, ok := stacks[info.ID]
+		if !ok {
+			stack = "(the goroutine did not start running)"
+		}
+		fmt.Fprintf(&report, "\n\n%s:\n%s", info.Label, stack)
+	}
+	return report.String()
+}
@@ -0,0 +1,126 @@
+package threadstest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blackpointcyber/threads"
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+// recorderT collects the errors and the cleanup functions
+// so the tests can check what VerifyNoLeaks reports.
+type recorderT struct {
+	testing.TB
+
+	errs     []string
+	cleanups []func()
+}
+
+func (r *recorderT) Helper() {}
+
+func (r *recorderT) Cleanup(fn func()) {
+	r.cleanups = append(r.cleanups, fn)
+}
+
+func (r *recorderT) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func (r *recorderT) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestVerifyNoLeaks(t *testing.T) {
+	ctx := context.Background()
+
+	defer func(timeout time.Duration) {
+		leakTimeout = timeout
+	}(leakTimeout)
+	leakTimeout = 50 * time.Millisecond
+
+	t.Run("should not report workers that return", func(t *testing.T) {
+		r := &recorderT{}
+		VerifyNoLeaks(r)
+
+		g := threads.NewGroup(ctx)
+		g.GoNamed("cooperative", func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+		g.SubGroup(func(ctx context.Context) error {
+			return nil
+		})
+		g.Cancel(nil)
+		tt.AssertNoErr(t, g.Wait())
+
+		r.finish()
+		tt.AssertEqual(t, len(r.errs), 0, r.errs)
+	})
+
+	t.Run("should report the workers that ignore the cancellation", func(t *testing.T) {
+		releaseCh := make(chan struct{})
+		defer close(releaseCh)
+
+		r := &recorderT{}
+		VerifyNoLeaks(r)
+
+		g := threads.NewGroup(ctx)
+		g.GoNamed("stubborn", func(ctx context.Context) error {
+			<-releaseCh
+			return nil
+		})
+		g.SubGroupNamed("parser", func(ctx context.Context) error {
+			<-releaseCh
+			return nil
+		})
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+		g.Cancel(nil)
+
+		err := g.WaitTimeout(10 * time.Millisecond)
+		tt.AssertEqual(t, err, context.DeadlineExceeded)
+
+		r.finish()
+		tt.AssertEqual(t, len(r.errs), 1)
+		tt.AssertContains(t, r.errs[0],
+			"5 goroutines started by the threads library are still running",
+			"worker stubborn:\ngoroutine ",
+			"worker parser:\ngoroutine ",
+			"worker parser/0:\ngoroutine ",
+			"Group.Wait:\ngoroutine ",
+			"threadstest.TestVerifyNoLeaks",
+		)
+	})
+
+	t.Run("should not report goroutines that leaked before the test", func(t *testing.T) {
+		releaseCh := make(chan struct{})
+
+		first := &recorderT{}
+		VerifyNoLeaks(first)
+
+		g := threads.NewGroup(ctx)
+		g.Go(func(ctx context.Context) error {
+			<-releaseCh
+			return nil
+		})
+
+		second := &recorderT{}
+		VerifyNoLeaks(second)
+		second.finish()
+		tt.AssertEqual(t, len(second.errs), 0, second.errs)
+
+		first.finish()
+		tt.AssertEqual(t, len(first.errs), 1)
+		tt.AssertContains(t, first.errs[0], "worker 0:\ngoroutine ")
+
+		close(releaseCh)
+		tt.AssertNoErr(t, g.Wait())
+	})
+}
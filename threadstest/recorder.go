@@ -0,0 +1,117 @@
+package threadstest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blackpointcyber/threads"
+)
+
+// IterationRecorder records the iterations of a PeriodicWorker, including
+// the wait it chose before each of the next ones, so tests can check how
+// the worker was scheduled without capturing the waits by hand:
+//
+//	rec := threadstest.NewIterationRecorder()
+//	worker := threads.PeriodicWorker(time.Minute, doWork, rec.Option())
+//	...
+//	rec.AssertIntervals(t, []time.Duration{time.Minute, 10 * time.Second})
+type IterationRecorder struct {
+	mux        sync.Mutex
+	iterations []threads.IterationInfo
+	changed    chan struct{}
+}
+
+// NewIterationRecorder returns an empty IterationRecorder.
+func NewIterationRecorder() *IterationRecorder {
+	return &IterationRecorder{
+		changed: make(chan struct{}),
+	}
+}
+
+// Option returns the PeriodicOption that makes the worker report its
+// iterations to the recorder, it can be combined with other observers
+// set with threads.WithIterationObserver.
+func (r *IterationRecorder) Option() threads.PeriodicOption {
+	return threads.WithIterationObserver(r.record)
+}
+
+func (r *IterationRecorder) record(info threads.IterationInfo) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.iterations = append(r.iterations, info)
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Iterations returns the iterations recorded so far, the
+// Err field keeps the signals returned by doWork, e.g.
+// RetryWorkerIn and AdjustInterval.
+func (r *IterationRecorder) Iterations() []threads.IterationInfo {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return append([]threads.IterationInfo{}, r.iterations...)
+}
+
+// Intervals returns the wait chosen after each recorded iteration,
+// which is zero if the worker stopped after that iteration.
+func (r *IterationRecorder) Intervals() []time.Duration {
+	iterations := r.Iterations()
+
+	intervals := make([]time.Duration, 0, len(iterations))
+	for _, info := range iterations {
+		intervals = append(intervals, info.NextWait)
+	}
+	return intervals
+}
+
+// WaitIterations blocks until at least n iterations are recorded.
+func (r *IterationRecorder) WaitIterations(n int) {
+	for {
+		r.mux.Lock()
+		numIterations := len(r.iterations)
+		changed := r.changed
+		r.mux.Unlock()
+
+		if numIterations >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// AssertIntervals fails the test if the waits chosen after
+// each iteration, see Intervals, don't match the expected ones.
+func (r *IterationRecorder) AssertIntervals(t testing.TB, expected []time.Duration) {
+	t.Helper()
+
+	intervals := r.Intervals()
+	if !equalDurations(intervals, expected) {
+		t.Errorf("expected the intervals %v but got %v", expected, intervals)
+	}
+}
+
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// AssertNumIterations fails the test if the number of
+// recorded iterations is not the expected one.
+func (r *IterationRecorder) AssertNumIterations(t testing.TB, expected int) {
+	t.Helper()
+
+	if n := len(r.Iterations()); n != expected {
+		t.Errorf("expected %d iterations but got %d", expected, n)
+	}
+}
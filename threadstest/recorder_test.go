@@ -0,0 +1,93 @@
+package threadstest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blackpointcyber/threads"
+	tt "github.com/blackpointcyber/threads/internal/testtools"
+)
+
+func TestIterationRecorder(t *testing.T) {
+	ctx := context.Background()
+	start := tt.ParseTime(t, "2024-01-01T00:00:00Z")
+
+	t.Run("should record each iteration and the wait chosen after it", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		ctx := clock.Context(ctx)
+
+		results := []error{
+			nil,
+			threads.RetryWorkerIn(time.Minute),
+			threads.AdjustInterval(30 * time.Minute),
+			nil,
+			fmt.Errorf("fakeErrMsg"),
+		}
+
+		var numCalls int
+		rec := NewIterationRecorder()
+		worker := threads.PeriodicWorker(time.Hour, func(ctx context.Context) error {
+			numCalls++
+			threads.ClockFromContext(ctx).Sleep(time.Second)
+			return results[numCalls-1]
+		}, rec.Option())
+
+		doneCh := make(chan error)
+		go func() {
+			doneCh <- worker(ctx)
+		}()
+
+		// Advances the clock through each iteration and the wait after it:
+		for i := 1; i < len(results); i++ {
+			clock.BlockUntilWaiters(1)
+			clock.Advance(time.Second)
+
+			rec.WaitIterations(i)
+			clock.BlockUntilWaiters(1)
+			clock.Advance(clock.PendingTimers()[0])
+		}
+		clock.BlockUntilWaiters(1)
+		clock.Advance(time.Second)
+
+		tt.AssertErrContains(t, <-doneCh, "fakeErrMsg")
+		rec.AssertNumIterations(t, 5)
+		rec.AssertIntervals(t, []time.Duration{
+			time.Hour,
+			time.Minute,
+			time.Hour,
+			30 * time.Minute,
+			0,
+		})
+
+		iterations := rec.Iterations()
+		tt.AssertEqual(t, iterations[0].Start, start)
+		tt.AssertEqual(t, iterations[1].Start, start.Add(time.Hour+time.Second))
+		tt.AssertEqual(t, iterations[2].Start, start.Add(time.Hour+time.Minute+2*time.Second))
+		for i, info := range iterations {
+			tt.AssertEqual(t, info.Duration, time.Second)
+			tt.AssertEqual(t, info.Err, results[i])
+		}
+	})
+
+	t.Run("should fail the test if the intervals don't match", func(t *testing.T) {
+		rec := NewIterationRecorder()
+		worker := threads.PeriodicWorker(time.Hour, func(ctx context.Context) error {
+			return fmt.Errorf("fakeErrMsg")
+		}, rec.Option())
+		worker(ctx)
+
+		r := &recorderT{}
+		rec.AssertIntervals(r, []time.Duration{0})
+		rec.AssertNumIterations(r, 1)
+		tt.AssertEqual(t, len(r.errs), 0, r.errs)
+
+		rec.AssertIntervals(r, []time.Duration{time.Hour})
+		rec.AssertNumIterations(r, 2)
+		tt.AssertEqual(t, r.errs, []string{
+			"expected the intervals [1h0m0s] but got [0s]",
+			"expected 2 iterations but got 1",
+		})
+	})
+}
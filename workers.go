@@ -3,6 +3,7 @@ package threads
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"time"
 )
 
@@ -18,6 +19,20 @@ func RetryWorkerIn(d time.Duration) error {
 	}
 }
 
+type retryWithBackoffErr struct {
+	error
+}
+
+// RetryWithBackoff makes a PeriodicWorker run the next iteration after a
+// delay that grows exponentially with each consecutive call, according to
+// the policy set with WithBackoffPolicy, the delay is reset once doWork
+// returns nil.
+func RetryWithBackoff() error {
+	return retryWithBackoffErr{
+		error: fmt.Errorf("this worker will be executed again after a backoff delay"),
+	}
+}
+
 type adjustIntervalErr struct {
 	error
 	d time.Duration
@@ -47,41 +62,540 @@ func intervalTypeToFunc(t any) func() time.Duration {
 	}
 }
 
+// PeriodicOption configures optional behaviors of a PeriodicWorker,
+// it should be passed as an argument to PeriodicWorker.
+type PeriodicOption func(cfg *periodicConfig)
+
+type periodicConfig struct {
+	jitter        time.Duration
+	jitterPercent float64
+	initialDelay  time.Duration
+	alignment     time.Duration
+
+	fixedRate bool
+	overrun   OverrunPolicy
+
+	timeout   time.Duration
+	onTimeout func(ctx context.Context, err error) error
+	observer  func(info IterationInfo)
+
+	backoff BackoffPolicy
+
+	// Only set for workers created with NewPeriodic:
+	ctrl *Periodic
+}
+
+// WithJitter adds a random delay between 0 and maxJitter to each
+// interval, so workers started at the same time drift apart.
+func WithJitter(maxJitter time.Duration) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		cfg.jitter = maxJitter
+	}
+}
+
+// WithJitterPercent works like WithJitter but the maximum jitter is
+// a percentage of each interval, e.g. 10 means up to 10% longer.
+func WithJitterPercent(percent float64) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		cfg.jitterPercent = percent
+	}
+}
+
+// WithRandomInitialDelay makes the worker wait a random delay
+// between 0 and maxDelay before the first iteration instead
+// of running it right after starting.
+func WithRandomInitialDelay(maxDelay time.Duration) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		cfg.initialDelay = maxDelay
+	}
+}
+
+// WithAlignment makes each iteration start on a wall-clock boundary that is
+// a multiple of d, e.g. time.Minute runs the iterations at the top of each
+// minute, the boundaries are calculated in UTC.
+//
+// Each iteration runs on the boundary closest to the end of the interval,
+// so an interval of 90s aligned to time.Minute runs every 2 minutes. Any
+// jitter is added after the boundary and the waits requested with
+// RetryWorkerIn are not aligned.
+//
+// The first iteration is not aligned, it still runs right after the
+// worker starts, or after WithRandomInitialDelay, and only the ones
+// after it start on the boundaries.
+func WithAlignment(d time.Duration) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		cfg.alignment = d
+	}
+}
+
+// OverrunPolicy decides what a fixed-rate PeriodicWorker does when
+// an iteration takes longer than the interval, see WithFixedRate.
+type OverrunPolicy int
+
+const (
+	// OverrunSkip skips the missed iterations and waits for the next
+	// one on the original schedule.
+	OverrunSkip OverrunPolicy = iota
+
+	// OverrunRunOnce runs a single iteration immediately and then goes
+	// back to the original schedule, just like a time.Ticker.
+	OverrunRunOnce
+
+	// OverrunCatchUp runs all the missed iterations one after the other
+	// until it catches up with the original schedule.
+	OverrunCatchUp
+)
+
+// WithFixedRate makes the interval be measured from the start of one
+// iteration to the start of the next, so the time spent on doWork doesn't
+// make the schedule drift, the policy decides what happens when an iteration
+// takes longer than the interval.
+//
+// The waits requested with RetryWorkerIn or RetryWithBackoff restart
+// the schedule from the moment the retried iteration starts.
+func WithFixedRate(policy OverrunPolicy) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		cfg.fixedRate = true
+		cfg.overrun = policy
+	}
+}
+
+// BackoffPolicy configures the delays used when doWork
+// returns RetryWithBackoff, see WithBackoffPolicy.
+type BackoffPolicy struct {
+	// The delay after the first failure, defaults to 1 second:
+	Initial time.Duration
+
+	// The maximum delay, defaults to 1 minute:
+	Max time.Duration
+
+	// How much the delay grows after each failure, defaults to 2:
+	Multiplier float64
+
+	// The fraction of each delay that is random, e.g. 0.5 makes
+	// the delays vary between 50% and 100% of their value:
+	Jitter float64
+
+	// By default the delay goes back to Initial when doWork returns nil,
+	// if this is set it keeps growing from where it was on the next failure:
+	NoResetOnSuccess bool
+}
+
+// WithBackoffPolicy sets the delays used when doWork returns RetryWithBackoff.
+func WithBackoffPolicy(policy BackoffPolicy) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		cfg.backoff = policy
+	}
+}
+
+// backoff tracks the consecutive failures of a worker.
+type backoff struct {
+	policy   BackoffPolicy
+	failures int
+}
+
+func (b *backoff) next() time.Duration {
+	initial := b.policy.Initial
+	if initial <= 0 {
+		initial = time.Second
+	}
+	maxDelay := b.policy.Max
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+	multiplier := b.policy.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	d := float64(initial)
+	for i := 0; i < b.failures && d < float64(maxDelay); i++ {
+		d *= multiplier
+	}
+	if d > float64(maxDelay) {
+		d = float64(maxDelay)
+	}
+	b.failures++
+
+	if b.policy.Jitter > 0 {
+		d -= d * b.policy.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+func (b *backoff) succeeded() {
+	if !b.policy.NoResetOnSuccess {
+		b.failures = 0
+	}
+}
+
+var ErrIterationTimeout = fmt.Errorf("periodic worker iteration timed out")
+
+// WithIterationTimeout runs each iteration with a context that is canceled
+// after the timeout, doWork must respect this context for the timeout to work.
+//
+// When an iteration times out and doWork returns an error, onTimeout receives
+// an error wrapping both ErrIterationTimeout and that error and decides
+// what happens next just like doWork does: returning nil continues on the
+// normal schedule, e.g. after logging the error, returning RetryWorkerIn(d)
+// runs the iteration again after d and any other error stops the worker.
+//
+// If onTimeout is nil the worker stops, returning the timeout error.
+//
+// If doWork returns nil even though the timeout expired the iteration
+// still counts as a success, only IterationInfo.TimedOut reports it.
+func WithIterationTimeout(timeout time.Duration, onTimeout func(ctx context.Context, err error) error) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		cfg.timeout = timeout
+		cfg.onTimeout = onTimeout
+	}
+}
+
+// IterationInfo describes an iteration of a PeriodicWorker,
+// see WithIterationObserver.
+type IterationInfo struct {
+	Start    time.Time
+	Duration time.Duration
+
+	// The error returned by doWork, including the
+	// signals like RetryWorkerIn and AdjustInterval:
+	Err error
+
+	// True if the iteration exceeded the WithIterationTimeout,
+	// even if doWork still returned nil afterwards:
+	TimedOut bool
+
+	// How long the worker waits before the next iteration, including
+	// the effects of the options and of signals like RetryWorkerIn,
+	// it is zero if the worker stopped after this iteration:
+	NextWait time.Duration
+}
+
+// WithIterationObserver sets a function that is called after each
+// iteration, once the wait for the next one is chosen, it can be used
+// for logging or for collecting metrics such as how long each
+// iteration took.
+//
+// If passed more than once the observers are called in order.
+func WithIterationObserver(fn func(info IterationInfo)) PeriodicOption {
+	return func(cfg *periodicConfig) {
+		prev := cfg.observer
+		if prev == nil {
+			cfg.observer = fn
+			return
+		}
+
+		cfg.observer = func(info IterationInfo) {
+			prev(info)
+			fn(info)
+		}
+	}
+}
+
+// observe reports the iteration to the observer, if any.
+func (cfg periodicConfig) observe(info IterationInfo, nextWait time.Duration) {
+	if cfg.observer != nil {
+		info.NextWait = nextWait
+		cfg.observer(info)
+	}
+}
+
+// runIteration runs doWork once applying the iteration timeout and returns
+// the description of the iteration and the error that decides what
+// happens next.
+func (cfg periodicConfig) runIteration(ctx context.Context, doWork Worker) (IterationInfo, error) {
+	clock := ClockFromContext(ctx)
+
+	iterationCtx := ctx
+	if cfg.timeout > 0 {
+		var cancel func()
+		iterationCtx, cancel = clock.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+
+	start := clock.Now()
+	if cfg.ctrl != nil {
+		cfg.ctrl.started(start)
+	}
+	err := doWork(iterationCtx)
+	duration := clock.Since(start)
+
+	// Only the iteration context expired:
+	timedOut := iterationCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+
+	info := IterationInfo{
+		Start:    start,
+		Duration: duration,
+		Err:      err,
+		TimedOut: timedOut,
+	}
+	// Iterations that still succeeded after the deadline are kept as successes:
+	if !timedOut || err == nil {
+		return info, err
+	}
+
+	timeoutErr := fmt.Errorf("%w after %v: %w", ErrIterationTimeout, cfg.timeout, err)
+	if cfg.onTimeout == nil {
+		return info, timeoutErr
+	}
+	return info, cfg.onTimeout(ctx, timeoutErr)
+}
+
+// nextWait applies the options to the interval between two iterations.
+func (cfg periodicConfig) nextWait(now time.Time, interval time.Duration) time.Duration {
+	if cfg.alignment > 0 {
+		interval = cfg.align(now, now.Add(interval)).Sub(now)
+	}
+
+	return interval + cfg.jitterFor(interval)
+}
+
+// align moves t to the closest boundary after now.
+func (cfg periodicConfig) align(now time.Time, t time.Time) time.Time {
+	t = t.Round(cfg.alignment)
+	if !t.After(now) {
+		t = t.Add(cfg.alignment)
+	}
+	return t
+}
+
+func (cfg periodicConfig) jitterFor(interval time.Duration) time.Duration {
+	maxJitter := cfg.jitter
+	if cfg.jitterPercent > 0 {
+		maxJitter += time.Duration(float64(interval) * cfg.jitterPercent / 100)
+	}
+
+	return randomDelay(maxJitter)
+}
+
+// randomDelay returns a random duration between 0 and maxDelay.
+func randomDelay(maxDelay time.Duration) time.Duration {
+	if maxDelay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maxDelay) + 1))
+}
+
+// PeriodicWorker creates a worker that runs doWork immediately and then once
+// per interval until either doWork returns an error or the context is cancelled.
+//
+// If doWork returns RetryWorkerIn(d) the next iteration runs after d, if it
+// returns RetryWithBackoff() it runs after an exponential backoff delay and
+// if it returns AdjustInterval(d) the interval changes to d from then on.
+//
+// The PeriodicOption arguments change how the iterations are scheduled
+// and executed, e.g. WithJitter, WithFixedRate or WithIterationTimeout.
 func PeriodicWorker[T intervalType](
 	baseIterationInterval T,
 	doWork Worker,
+	opts ...PeriodicOption,
 ) Worker {
 	iterationInterval := intervalTypeToFunc(baseIterationInterval)
 
+	var cfg periodicConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	return func(ctx context.Context) error {
-		// This allows us to mock time.After using
-		// the ContextWithTimeMock function:
-		timeAfter := getTimeAfter(ctx)
+		// This allows us to mock the time using
+		// the ContextWithClock function:
+		clock := ClockFromContext(ctx)
 
+		if cfg.initialDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-clock.After(randomDelay(cfg.initialDelay)):
+			}
+		}
+
+		if cfg.ctrl != nil && !cfg.ctrl.waitResumed(ctx) {
+			return nil
+		}
+
+		if cfg.fixedRate {
+			return cfg.runFixedRate(ctx, clock, iterationInterval, doWork)
+		}
+
+		backoff := backoff{policy: cfg.backoff}
 		for {
 			nextIteration := iterationInterval()
 
-			err := doWork(ctx)
-			if err != nil {
-				switch knownErr := err.(type) {
-				case retryWorkerErr:
-					nextIteration = knownErr.d
-				case adjustIntervalErr:
-					iterationInterval = func() time.Duration {
-						return knownErr.d
-					}
-				default:
-					return err
+			info, err := cfg.runIteration(ctx, doWork)
+			end := clock.Now()
+
+			retrying := false
+			switch knownErr := err.(type) {
+			case nil:
+				backoff.succeeded()
+			case retryWorkerErr:
+				nextIteration = knownErr.d
+				retrying = true
+			case retryWithBackoffErr:
+				nextIteration = backoff.next()
+				retrying = true
+			case adjustIntervalErr:
+				iterationInterval = func() time.Duration {
+					return knownErr.d
 				}
+			default:
+				cfg.observe(info, 0)
+				return err
+			}
+			if !retrying {
+				nextIteration = cfg.nextWait(end, nextIteration)
 			}
+			cfg.observe(info, nextIteration)
 
-			// Blocks until the next iteration
-			// or until the context is cancelled:
-			select {
-			case <-ctx.Done():
+			wake, ok := cfg.sleep(ctx, clock, nextIteration, end)
+			if !ok {
 				return nil
-			case <-timeAfter(nextIteration):
 			}
+			if wake.interval > 0 {
+				iterationInterval = func() time.Duration {
+					return wake.interval
+				}
+			}
+		}
+	}
+}
+
+// runFixedRate is the loop of a PeriodicWorker using WithFixedRate.
+//
+// The time is tracked using the values received from the timer, so the
+// schedule stays consistent even if the clock only mocks Clock.After.
+func (cfg periodicConfig) runFixedRate(
+	ctx context.Context,
+	clock Clock,
+	iterationInterval func() time.Duration,
+	doWork Worker,
+) error {
+	now := clock.Now()
+
+	// The time when the current iteration was supposed to start:
+	slot := now
+	backoff := backoff{policy: cfg.backoff}
+	for {
+		interval := iterationInterval()
+
+		info, err := cfg.runIteration(ctx, doWork)
+		end := now.Add(info.Duration)
+
+		var wait time.Duration
+		retrying := false
+		switch knownErr := err.(type) {
+		case nil:
+			backoff.succeeded()
+		case retryWorkerErr:
+			wait = knownErr.d
+			retrying = true
+		case retryWithBackoffErr:
+			wait = backoff.next()
+			retrying = true
+		case adjustIntervalErr:
+			iterationInterval = func() time.Duration {
+				return knownErr.d
+			}
+		default:
+			cfg.observe(info, 0)
+			return err
+		}
+
+		// The time SetInterval uses for rescheduling the next iteration:
+		base := slot
+		if retrying {
+			base = end
+			slot = end.Add(wait)
+		} else {
+			slot, wait = cfg.nextSlot(slot, end, interval)
+			wait += cfg.jitterFor(interval)
+		}
+		cfg.observe(info, wait)
+
+		wake, ok := cfg.sleep(ctx, clock, wait, base)
+		if !ok {
+			return nil
+		}
+		now = wake.now
+
+		expected := end.Add(wait)
+		if wake.interval > 0 {
+			iterationInterval = func() time.Duration {
+				return wake.interval
+			}
+			slot = base.Add(wake.interval)
+			expected = slot
+		}
+		if wake.triggered {
+			// The schedule restarts from the triggered iteration:
+			slot = now
+			continue
 		}
+
+		// Ensures the time doesn't go backwards
+		// if the timer fires a bit early:
+		if now.Before(expected) {
+			now = expected
+		}
+	}
+}
+
+// nextSlot returns when the next iteration should start, according to the
+// schedule, and how long to wait for it after an iteration that ended at end.
+func (cfg periodicConfig) nextSlot(slot time.Time, end time.Time, interval time.Duration) (time.Time, time.Duration) {
+	next := slot.Add(interval)
+	if cfg.alignment > 0 {
+		next = cfg.align(slot, next)
+	}
+	if end.Before(next) || interval <= 0 {
+		return next, next.Sub(end)
+	}
+
+	// Number of slots missed after next:
+	missed := end.Sub(next) / interval
+
+	switch cfg.overrun {
+	case OverrunCatchUp:
+		return next, 0
+	case OverrunRunOnce:
+		return next.Add(missed * interval), 0
+	default:
+		next = next.Add((missed + 1) * interval)
+		return next, next.Sub(end)
+	}
+}
+
+// wakeUp describes why a PeriodicWorker stopped waiting.
+type wakeUp struct {
+	// The time received from the timer or, if the
+	// iteration was triggered, the current time:
+	now time.Time
+
+	// Set if the iteration was started by Periodic.Trigger:
+	triggered bool
+
+	// Set if Periodic.SetInterval was called while waiting:
+	interval time.Duration
+}
+
+// sleep blocks until the next iteration or until the context is cancelled,
+// in which case it returns false. The base is the time the interval is
+// measured from if it changes while waiting.
+func (cfg periodicConfig) sleep(
+	ctx context.Context,
+	clock Clock,
+	wait time.Duration,
+	base time.Time,
+) (wakeUp, bool) {
+	if cfg.ctrl != nil {
+		return cfg.ctrl.wait(ctx, clock, wait, base)
+	}
+
+	select {
+	case <-ctx.Done():
+		return wakeUp{}, false
+	case now := <-clock.After(wait):
+		return wakeUp{now: now}, true
 	}
 }
@@ -2,6 +2,7 @@ package threads
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -122,3 +123,498 @@ func TestPeriodicWorker(t *testing.T) {
 		})
 	})
 }
+
+func TestPeriodicWorkerOptions(t *testing.T) {
+	ctx := context.Background()
+
+	// collectWaits runs the worker until it calls time.After n times
+	// and returns the durations passed to it.
+	collectWaits := func(n int, worker Worker) []time.Duration {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			for i := 0; i < n-1; i++ {
+				timeAfterArgs = append(timeAfterArgs, <-waitCh)
+				triggerCh <- time.Now()
+			}
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		worker(ctx)
+		return timeAfterArgs
+	}
+
+	noop := func(ctx context.Context) error {
+		return nil
+	}
+
+	t.Run("should add a random jitter to each interval", func(t *testing.T) {
+		waits := collectWaits(20, PeriodicWorker(time.Second, noop, WithJitter(time.Second)))
+
+		tt.AssertEqual(t, len(waits), 20)
+		distinct := map[time.Duration]bool{}
+		for _, wait := range waits {
+			tt.AssertEqual(t, wait >= time.Second && wait <= 2*time.Second, true, "unexpected wait: %v", wait)
+			distinct[wait] = true
+		}
+		tt.AssertEqual(t, len(distinct) > 1, true)
+	})
+
+	t.Run("should add a jitter proportional to the interval", func(t *testing.T) {
+		waits := collectWaits(20, PeriodicWorker(10*time.Second, noop, WithJitterPercent(10)))
+
+		tt.AssertEqual(t, len(waits), 20)
+		for _, wait := range waits {
+			tt.AssertEqual(t, wait >= 10*time.Second && wait <= 11*time.Second, true, "unexpected wait: %v", wait)
+		}
+	})
+
+	t.Run("should not add jitter to the waits requested with RetryWorkerIn", func(t *testing.T) {
+		waits := collectWaits(3, PeriodicWorker(time.Second, func(ctx context.Context) error {
+			return RetryWorkerIn(time.Minute)
+		}, WithJitter(time.Second)))
+
+		tt.AssertEqual(t, waits, []time.Duration{time.Minute, time.Minute, time.Minute})
+	})
+
+	t.Run("should wait a random delay before the first iteration", func(t *testing.T) {
+		var numCalls int
+		waits := collectWaits(2, PeriodicWorker(time.Second, func(ctx context.Context) error {
+			numCalls++
+			return nil
+		}, WithRandomInitialDelay(time.Minute)))
+
+		tt.AssertEqual(t, numCalls, 1)
+		tt.AssertEqual(t, len(waits), 2)
+		tt.AssertEqual(t, waits[0] >= 0 && waits[0] <= time.Minute, true, "unexpected initial delay: %v", waits[0])
+		tt.AssertEqual(t, waits[1], time.Second)
+	})
+
+	t.Run("should align the iterations to the wall clock", func(t *testing.T) {
+		var waits []time.Duration
+		var iterationTimes []time.Time
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			waits = append(waits, <-waitCh)
+			cancel()
+		}))
+
+		PeriodicWorker(time.Second, func(ctx context.Context) error {
+			iterationTimes = append(iterationTimes, time.Now())
+			return nil
+		}, WithAlignment(time.Hour))(ctx)
+
+		tt.AssertEqual(t, len(waits), 1)
+		tt.AssertEqual(t, waits[0] > 0 && waits[0] <= time.Hour, true, "unexpected wait: %v", waits[0])
+
+		next := iterationTimes[0].Add(waits[0])
+		tt.AssertApproxTime(t, time.Second, next, next.Round(time.Hour), "expected %v to be at the top of an hour", next)
+	})
+
+	t.Run("should round the interval to the closest boundary", func(t *testing.T) {
+		cfg := periodicConfig{alignment: time.Minute}
+
+		now := tt.ParseTime(t, "2024-01-01T10:00:00Z").Add(200 * time.Millisecond)
+		tt.AssertEqual(t, cfg.nextWait(now, 90*time.Second), 2*time.Minute-200*time.Millisecond)
+		tt.AssertEqual(t, cfg.nextWait(now, time.Second), time.Minute-200*time.Millisecond)
+		tt.AssertEqual(t, cfg.nextWait(now, time.Minute), time.Minute-200*time.Millisecond)
+		tt.AssertEqual(t, cfg.nextWait(now, 20*time.Minute+10*time.Second), 20*time.Minute-200*time.Millisecond)
+	})
+}
+
+func TestPeriodicWorkerFixedRate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should measure the interval from the start of each iteration", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		PeriodicWorker(time.Second, func(ctx context.Context) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}, WithFixedRate(OverrunSkip))(ctx)
+
+		tt.AssertEqual(t, len(timeAfterArgs), 1)
+		tt.AssertEqual(t, timeAfterArgs[0] <= time.Second-20*time.Millisecond, true, "unexpected wait: %v", timeAfterArgs[0])
+		tt.AssertEqual(t, timeAfterArgs[0] > time.Second-500*time.Millisecond, true, "unexpected wait: %v", timeAfterArgs[0])
+	})
+
+	for _, test := range []struct {
+		desc          string
+		policy        OverrunPolicy
+		expectedWaits []time.Duration
+	}{
+		{
+			desc:          "should skip the missed iterations",
+			policy:        OverrunSkip,
+			expectedWaits: []time.Duration{10 * time.Second, 5 * time.Second},
+		},
+		{
+			desc:          "should run once immediately after missing iterations",
+			policy:        OverrunRunOnce,
+			expectedWaits: []time.Duration{10 * time.Second, 0, 5 * time.Second},
+		},
+		{
+			desc:          "should run all missed iterations",
+			policy:        OverrunCatchUp,
+			expectedWaits: []time.Duration{10 * time.Second, 0, 0, 5 * time.Second},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			startTime := time.Now()
+
+			var timeAfterArgs []time.Duration
+			ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+				// The first iteration was supposed to run after 10s but
+				// the timer fires after 35s, e.g. because the machine was
+				// suspended, so the iteration of 20s and 30s are missed:
+				timeAfterArgs = append(timeAfterArgs, <-waitCh)
+				triggerCh <- startTime.Add(35 * time.Second)
+
+				for i := 1; i < len(test.expectedWaits); i++ {
+					timeAfterArgs = append(timeAfterArgs, <-waitCh)
+					triggerCh <- time.Time{}
+				}
+				cancel()
+			}))
+
+			PeriodicWorker(10*time.Second, func(ctx context.Context) error {
+				return nil
+			}, WithFixedRate(test.policy))(ctx)
+
+			tt.AssertEqual(t, len(timeAfterArgs), len(test.expectedWaits))
+			for i, expected := range test.expectedWaits {
+				tt.AssertApproxDuration(t, time.Second, timeAfterArgs[i], expected,
+					"expected wait %d to be %v but got %v", i, expected, timeAfterArgs[i],
+				)
+			}
+		})
+	}
+
+	t.Run("should restart the schedule after a retry", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			triggerCh <- time.Time{}
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		var numCalls int
+		PeriodicWorker(10*time.Second, func(ctx context.Context) error {
+			numCalls++
+			if numCalls == 1 {
+				return RetryWorkerIn(3 * time.Second)
+			}
+			return nil
+		}, WithFixedRate(OverrunSkip))(ctx)
+
+		tt.AssertEqual(t, len(timeAfterArgs), 2)
+		tt.AssertEqual(t, timeAfterArgs[0], 3*time.Second)
+		tt.AssertApproxDuration(t, time.Second, timeAfterArgs[1], 10*time.Second, "unexpected wait: %v", timeAfterArgs[1])
+	})
+}
+
+func TestPeriodicWorkerIterationTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	hangingWork := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	t.Run("should stop the worker if an iteration times out", func(t *testing.T) {
+		err := PeriodicWorker(time.Second, hangingWork,
+			WithIterationTimeout(time.Millisecond, nil),
+		)(ctx)
+
+		tt.AssertErrContains(t, err, "iteration timed out after 1ms", "context deadline exceeded")
+		tt.AssertEqual(t, errors.Is(err, ErrIterationTimeout), true)
+	})
+
+	t.Run("should continue on the normal schedule if onTimeout returns nil", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			triggerCh <- time.Now()
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		var timeoutErrs []error
+		err := PeriodicWorker(time.Second, hangingWork,
+			WithIterationTimeout(time.Millisecond, func(ctx context.Context, err error) error {
+				timeoutErrs = append(timeoutErrs, err)
+				return nil
+			}),
+		)(ctx)
+
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, timeAfterArgs, []time.Duration{time.Second, time.Second})
+		tt.AssertEqual(t, len(timeoutErrs), 2)
+		tt.AssertEqual(t, errors.Is(timeoutErrs[0], ErrIterationTimeout), true)
+	})
+
+	t.Run("should retry if onTimeout returns a retryError", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			triggerCh <- time.Now()
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		var numCalls int
+		err := PeriodicWorker(time.Second, func(ctx context.Context) error {
+			numCalls++
+			if numCalls == 1 {
+				return hangingWork(ctx)
+			}
+			return nil
+		}, WithIterationTimeout(time.Millisecond, func(ctx context.Context, err error) error {
+			return RetryWorkerIn(10 * time.Millisecond)
+		}))(ctx)
+
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, timeAfterArgs, []time.Duration{10 * time.Millisecond, time.Second})
+	})
+
+	t.Run("should not handle iterations that succeed after the timeout as timeouts", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		var infos []IterationInfo
+		err := PeriodicWorker(time.Second, func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		},
+			WithIterationTimeout(time.Millisecond, nil),
+			WithIterationObserver(func(info IterationInfo) {
+				infos = append(infos, info)
+			}),
+		)(ctx)
+
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, timeAfterArgs, []time.Duration{time.Second})
+		tt.AssertEqual(t, len(infos), 1)
+		tt.AssertEqual(t, infos[0].TimedOut, true)
+		tt.AssertEqual(t, infos[0].Err, nil)
+	})
+
+	t.Run("should report the duration of each iteration", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			<-waitCh
+			triggerCh <- time.Now()
+			<-waitCh
+			cancel()
+		}))
+
+		var numCalls int
+		var infos []IterationInfo
+		err := PeriodicWorker(time.Second, func(ctx context.Context) error {
+			numCalls++
+			if numCalls == 1 {
+				return hangingWork(ctx)
+			}
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		},
+			WithIterationTimeout(10*time.Millisecond, func(ctx context.Context, err error) error {
+				return nil
+			}),
+			WithIterationObserver(func(info IterationInfo) {
+				infos = append(infos, info)
+			}),
+		)(ctx)
+
+		tt.AssertNoErr(t, err)
+		tt.AssertEqual(t, len(infos), 2)
+
+		tt.AssertEqual(t, infos[0].TimedOut, true)
+		tt.AssertEqual(t, infos[0].Err, context.DeadlineExceeded)
+		tt.AssertEqual(t, infos[0].Duration >= 10*time.Millisecond, true, "unexpected duration: %v", infos[0].Duration)
+
+		tt.AssertEqual(t, infos[1].TimedOut, false)
+		tt.AssertEqual(t, infos[1].Err, nil)
+		tt.AssertEqual(t, infos[1].Duration >= 5*time.Millisecond, true, "unexpected duration: %v", infos[1].Duration)
+		tt.AssertEqual(t, infos[1].Start.After(infos[0].Start), true)
+	})
+
+	t.Run("should not report a timeout if the worker context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+
+		var timedOut bool
+		err := PeriodicWorker(time.Second, hangingWork,
+			WithIterationTimeout(time.Second, nil),
+			WithIterationObserver(func(info IterationInfo) {
+				timedOut = info.TimedOut
+			}),
+		)(ctx)
+
+		tt.AssertErrContains(t, err, "context deadline exceeded")
+		tt.AssertEqual(t, errors.Is(err, ErrIterationTimeout), false)
+		tt.AssertEqual(t, timedOut, false)
+	})
+
+	t.Run("should report the next wait to all observers", func(t *testing.T) {
+		ctx := ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			for i := 0; i < 3; i++ {
+				<-waitCh
+				triggerCh <- time.Time{}
+			}
+		}))
+
+		results := []error{RetryWorkerIn(time.Minute), AdjustInterval(time.Second), nil, fmt.Errorf("fakeErrMsg")}
+		var numCalls int
+		var firstWaits, secondWaits []time.Duration
+		err := PeriodicWorker(time.Hour, func(ctx context.Context) error {
+			numCalls++
+			return results[numCalls-1]
+		},
+			WithIterationObserver(func(info IterationInfo) {
+				firstWaits = append(firstWaits, info.NextWait)
+			}),
+			WithIterationObserver(func(info IterationInfo) {
+				secondWaits = append(secondWaits, info.NextWait)
+			}),
+		)(ctx)
+
+		tt.AssertErrContains(t, err, "fakeErrMsg")
+		tt.AssertEqual(t, firstWaits, []time.Duration{time.Minute, time.Hour, time.Second, 0})
+		tt.AssertEqual(t, secondWaits, firstWaits)
+	})
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	ctx := context.Background()
+
+	// collectWaits runs the worker returning the given
+	// results and the durations passed to time.After.
+	collectWaits := func(results []error, opts ...PeriodicOption) []time.Duration {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var timeAfterArgs []time.Duration
+		ctx = ContextWithTimeMock(ctx, tt.MockTimeAfter(func(triggerCh chan time.Time, waitCh chan time.Duration) {
+			for i := 0; i < len(results)-1; i++ {
+				timeAfterArgs = append(timeAfterArgs, <-waitCh)
+				triggerCh <- time.Time{}
+			}
+			timeAfterArgs = append(timeAfterArgs, <-waitCh)
+			cancel()
+		}))
+
+		var numCalls int
+		PeriodicWorker(time.Hour, func(ctx context.Context) error {
+			numCalls++
+			return results[numCalls-1]
+		}, opts...)(ctx)
+
+		return timeAfterArgs
+	}
+
+	retry := RetryWithBackoff()
+
+	t.Run("should grow the delay exponentially and reset it on success", func(t *testing.T) {
+		waits := collectWaits(
+			[]error{retry, retry, retry, retry, retry, nil, retry},
+			WithBackoffPolicy(BackoffPolicy{
+				Initial: time.Second,
+				Max:     8 * time.Second,
+			}),
+		)
+
+		tt.AssertEqual(t, waits, []time.Duration{
+			time.Second,
+			2 * time.Second,
+			4 * time.Second,
+			8 * time.Second,
+			8 * time.Second,
+			time.Hour,
+			time.Second,
+		})
+	})
+
+	t.Run("should use the default policy if none is set", func(t *testing.T) {
+		waits := collectWaits([]error{retry, retry, retry})
+		tt.AssertEqual(t, waits, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second})
+	})
+
+	t.Run("should use a custom multiplier and keep the delay after a success if configured to", func(t *testing.T) {
+		waits := collectWaits(
+			[]error{retry, retry, nil, retry},
+			WithBackoffPolicy(BackoffPolicy{
+				Initial:          time.Second,
+				Max:              time.Minute,
+				Multiplier:       3,
+				NoResetOnSuccess: true,
+			}),
+		)
+
+		tt.AssertEqual(t, waits, []time.Duration{
+			time.Second,
+			3 * time.Second,
+			time.Hour,
+			9 * time.Second,
+		})
+	})
+
+	t.Run("should not reset the delay on other signals", func(t *testing.T) {
+		waits := collectWaits([]error{retry, RetryWorkerIn(time.Minute), retry})
+		tt.AssertEqual(t, waits, []time.Duration{time.Second, time.Minute, 2 * time.Second})
+	})
+
+	t.Run("should also work on the fixed-rate mode", func(t *testing.T) {
+		waits := collectWaits([]error{retry, retry}, WithFixedRate(OverrunSkip))
+		tt.AssertEqual(t, waits, []time.Duration{time.Second, 2 * time.Second})
+	})
+
+	t.Run("should apply the jitter to each delay", func(t *testing.T) {
+		b := backoff{policy: BackoffPolicy{
+			Initial: 10 * time.Second,
+			Max:     10 * time.Second,
+			Jitter:  0.5,
+		}}
+
+		distinct := map[time.Duration]bool{}
+		for i := 0; i < 20; i++ {
+			d := b.next()
+			tt.AssertEqual(t, d >= 5*time.Second && d <= 10*time.Second, true, "unexpected delay: %v", d)
+			distinct[d] = true
+		}
+		tt.AssertEqual(t, len(distinct) > 1, true)
+	})
+}